@@ -1,31 +1,110 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/jhwbarlow/mockcicd/pkg/build"
 	"github.com/jhwbarlow/mockcicd/pkg/check"
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
+	"github.com/jhwbarlow/mockcicd/pkg/filter"
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+	"github.com/jhwbarlow/mockcicd/pkg/health"
+	"github.com/jhwbarlow/mockcicd/pkg/history"
+	"github.com/jhwbarlow/mockcicd/pkg/imagecheck"
 	"github.com/jhwbarlow/mockcicd/pkg/install"
+	"github.com/jhwbarlow/mockcicd/pkg/logarchive"
+	"github.com/jhwbarlow/mockcicd/pkg/logging"
+	"github.com/jhwbarlow/mockcicd/pkg/metrics"
 	"github.com/jhwbarlow/mockcicd/pkg/obtain"
 	"github.com/jhwbarlow/mockcicd/pkg/prepare"
+	"github.com/jhwbarlow/mockcicd/pkg/preview"
 	"github.com/jhwbarlow/mockcicd/pkg/push"
+	"github.com/jhwbarlow/mockcicd/pkg/revision"
 	"github.com/jhwbarlow/mockcicd/pkg/tagdeduce"
+	"github.com/jhwbarlow/mockcicd/pkg/trigger"
 )
 
 type config struct {
-	SrcDirPath       string        `required:"true"`
-	GitRepoURL       string        `required:"true"`
-	GitBranch        string        `required:"true"`
-	ImageName        string        `required:"true"`
-	HelmChartPath    string        `required:"true"`
-	HelmK8sNamespace string        `required:"true"`
-	HelmReleaseName  string        `required:"true"`
-	InstallTimeout   time.Duration `required:"true"`
-	PollPeriod       time.Duration `required:"true"`
+	SrcDirPath           string `required:"true"`
+	GitRepoURL           string `required:"true"`
+	GitBranch            string
+	GitBranchPattern     string
+	GitPullRequests      bool
+	GitTagPattern        string
+	GitTagConstraint     string
+	GitUsername          string
+	GitPassword          string
+	GitPasswordFilePath  string
+	GitCredentialHelper  string
+	GitSSHKeyPath        string
+	GitSSHKeyPassphrase  string
+	GitSSHKnownHostsPath string
+	GitCloneDepth        int
+	GitSparsePaths       []string
+	GitSubmodules        bool
+	GitLFS               bool
+	GitIncludePaths      []string
+	GitExcludePaths      []string
+	ImageName            string `required:"true"`
+	DockerfilePath       string
+	DockerfileInRepo     bool
+	BuildArgs            []string
+	BuildLabels          []string
+	BuildTarget          string
+	BuildPlatforms       []string
+	BuildCacheFrom       []string
+	BuildCacheTo         []string
+	BuildPrune           bool
+	BuildPruneKeepAge    time.Duration
+	BuildPruneKeepMB     int
+	Builder              string `default:"docker"`
+	OCIBaseImage         string
+	OCISourcePath        string `default:"."`
+	OCIDestPath          string `default:"/app"`
+	OCILayoutPath        string
+	HelmChartPath        string        `required:"true"`
+	HelmK8sNamespace     string        `required:"true"`
+	HelmReleaseName      string        `required:"true"`
+	InstallTimeout       time.Duration `required:"true"`
+	PollPeriod           time.Duration `required:"true"`
+	HistoryFilePath      string        `default:"history.jsonl"`
+	ListenAddr           string
+	WebhookSecret        string
+	MetricsEnabled       bool
+	LivenessPolls        int           `default:"10"`
+	ShutdownTimeout      time.Duration `default:"30s"`
+	LogFormat            string        `default:"text"`
+	LogDirPath           string
+	LogRetainCount       int           `default:"50"`
+	LogRetainAge         time.Duration `default:"168h"`
+	ObtainMode           string        `default:"clone"`
+	UpdateStrategy       string        `default:"pull"`
+	ObtainTimeout        time.Duration `default:"10m"`
+	CheckTimeout         time.Duration `default:"1m"`
+	UpdateTimeout        time.Duration `default:"5m"`
+	DeduceTimeout        time.Duration `default:"1m"`
+	BuildTimeout         time.Duration `default:"30m"`
+	PushTimeout          time.Duration `default:"10m"`
+	PruneTimeout         time.Duration `default:"10m"`
+	ImageCheckTimeout    time.Duration `default:"1m"`
+	SkipExistingImages   bool
 }
 
 const (
@@ -40,145 +119,1104 @@ func main() {
 		log.Fatalf("Config error: %v", err)
 	}
 
+	logger, err := logging.New(os.Stderr, config.LogFormat, slog.LevelInfo)
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	// Route anything logged by libraries through the structured logger too
+	slog.SetDefault(logger)
+
 	// Create dependencies for injection
-	preparer := prepare.NewFilesystemPreparer()
-	obtainer := obtain.NewGitCloneObtainer(config.GitRepoURL, config.GitBranch, preparer)
-	tagDeducer := tagdeduce.NewGitHashTagDeducer(config.SrcDirPath)
-	builder := build.NewDockerCLIBuilder()
-	pusher := push.NewDockerCLIPusher()
+	gitAuth, err := gitAuthProvider(config)
+	if err != nil {
+		fatal(logger, "configuring Git authentication", err)
+	}
+
+	if err := checkModes(config); err != nil {
+		fatal(logger, "Config error", err)
+	}
+
+	// Preview environments are deployed for each branch matching the branch pattern and
+	// each pull request, if enabled, otherwise the single ref selected is deployed
+	previewMatcher, err := previewRefMatcher(config)
+	if err != nil {
+		fatal(logger, "configuring Git ref selection", err)
+	}
+
+	var selector gitutil.RefSelector
+	var refMatcher gitutil.RefMatcher
+	if previewMatcher != nil {
+		refMatcher = previewMatcher
+	} else {
+		if selector, err = refSelector(config); err != nil {
+			fatal(logger, "configuring Git ref selection", err)
+		}
+		refMatcher = selector
+	}
+
 	installer := install.NewHelmK8sAtomicInstaller(config.HelmReleaseName,
 		config.HelmK8sNamespace,
-		config.HelmChartPath)
-	checker := check.NewGitChecker(config.SrcDirPath, config.GitBranch)
-	updater := obtain.NewGitPullUpdater(config.GitBranch)
-
-	if err := setup(obtainer,
-		tagDeducer,
-		builder,
-		pusher,
-		installer,
-		config.SrcDirPath,
-		config.ImageName,
-		config.InstallTimeout); err != nil {
-		log.Fatalf("Setup error: %v", err)
-	}
-
-	// Run does not return
-	run(tagDeducer,
-		builder,
-		pusher,
-		installer,
-		checker,
-		updater,
-		config.SrcDirPath,
-		config.ImageName,
-		config.PollPeriod,
-		config.InstallTimeout,
-		nil)
-}
-
-func setup(obtainer obtain.Obtainer,
-	tagDeducer tagdeduce.TagDeducer,
-	builder build.Builder,
-	pusher push.Pusher,
-	installer install.Installer,
+		config.HelmChartPath,
+		componentLogger(logger, "installer"))
+	store := history.NewJSONLinesStore(config.HistoryFilePath, componentLogger(logger, "history"))
+
+	// Filtering changes by path is optional
+	var pathFilter *filter.GitPathFilter
+	if len(config.GitIncludePaths) > 0 || len(config.GitExcludePaths) > 0 {
+		if pathFilter, err = filter.NewGitPathFilter(config.SrcDirPath,
+			config.GitIncludePaths,
+			config.GitExcludePaths,
+			componentLogger(logger, "filter")); err != nil {
+			fatal(logger, "configuring path filter", err)
+		}
+	}
+
+	buildArgs, err := build.ParseVariables(config.BuildArgs)
+	if err != nil {
+		fatal(logger, "configuring build args", err)
+	}
+
+	buildLabels, err := build.ParseVariables(config.BuildLabels)
+	if err != nil {
+		fatal(logger, "configuring build labels", err)
+	}
+
+	cacheFrom, err := build.ParseCaches(config.BuildCacheFrom)
+	if err != nil {
+		fatal(logger, "configuring build caches to import", err)
+	}
+
+	cacheTo, err := build.ParseCaches(config.BuildCacheTo)
+	if err != nil {
+		fatal(logger, "configuring build caches to export", err)
+	}
+
+	buildOptions := build.Options{
+		DockerfilePath:      config.DockerfilePath,
+		DockerfileInContext: config.DockerfileInRepo,
+		BuildArgs:           buildArgs,
+		Target:              config.BuildTarget,
+		Labels:              buildLabels,
+		Platforms:           config.BuildPlatforms,
+		CacheFrom:           cacheFrom,
+		CacheTo:             cacheTo,
+	}
+
+	// Archiving of run output is optional
+	var archiver logarchive.Archiver
+	if config.LogDirPath != "" {
+		archiver = logarchive.NewDirArchiver(config.LogDirPath,
+			config.LogRetainCount,
+			config.LogRetainAge,
+			componentLogger(logger, "archiver"))
+	}
+
+	backend, err := newImageBackend(config, buildOptions, logger)
+	if err != nil {
+		fatal(logger, "configuring builder", err)
+	}
+
+	shared := &sharedComponents{
+		gitAuth:    gitAuth,
+		builder:    backend.builder,
+		pusher:     backend.pusher,
+		pruner:     backend.pruner,
+		archiver:   archiver,
+		pathFilter: pathFilter,
+	}
+
+	// Checking the registry for the image before building it is optional
+	if config.SkipExistingImages {
+		shared.imageCheck = imagecheck.NewRegistryChecker(componentLogger(logger, "imagecheck"))
+	}
+
+	// The webhook receiver is optional, with polling alone used if it is not configured
+	var triggerer trigger.Triggerer
+	mux := http.NewServeMux()
+	if config.WebhookSecret != "" {
+		webhookTriggerer := trigger.NewWebhookTriggerer(refMatcher,
+			config.WebhookSecret,
+			componentLogger(logger, "triggerer"))
+		mux.Handle("/webhook", webhookTriggerer)
+		triggerer = webhookTriggerer
+	}
+
+	// Metrics are optional, but are always recorded so the recorder need not be optional
+	registry := prometheus.NewRegistry()
+	recorder := metrics.NewPrometheusRecorder(registry)
+	if config.MetricsEnabled {
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	}
+
+	// Health is always monitored, and served if there is an HTTP server
+	monitor := health.NewMonitor(time.Duration(config.LivenessPolls) * config.PollPeriod)
+	mux.Handle("/healthz", monitor.LivenessHandler())
+	mux.Handle("/readyz", monitor.ReadinessHandler())
+
+	if config.ListenAddr != "" {
+		go func() {
+			logger.Info("listening", "addr", config.ListenAddr)
+			if err := http.ListenAndServe(config.ListenAddr, mux); err != nil {
+				fatal(logger, "HTTP server error", err)
+			}
+		}()
+	} else if triggerer != nil || config.MetricsEnabled {
+		fatal(logger, "Config error", errors.New("webhook secret is set or metrics are enabled, but no listen address is configured"))
+	}
+
+	// The done channel is closed upon the first shutdown signal, allowing any in-flight
+	// build and install to complete. If it does not complete in time, it is cancelled.
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := handleSignals(logger, done, cancel, config.ShutdownTimeout)
+
+	if previewMatcher != nil {
+		supervisor := &previewSupervisor{
+			lister: preview.NewGitRemoteLister(config.GitRepoURL,
+				previewMatcher,
+				gitAuth,
+				componentLogger(logger, "lister")),
+			newEnvironment: func(ref gitplumbing.ReferenceName) (*pipeline, install.Uninstaller) {
+				environment := preview.Environment(ref)
+				// The namespace is the environment's own, so is deleted along with the release
+				envInstaller := installer.ForEnvironment(environment,
+					preview.Name(config.HelmReleaseName, environment),
+					preview.Name(config.HelmK8sNamespace, environment))
+
+				// Branches are selected as such so that they are pulled rather than reset
+				var envSelector gitutil.RefSelector = gitutil.NewRefNameSelector(ref)
+				if ref.IsBranch() {
+					envSelector = gitutil.NewBranchSelector(ref.Short())
+				}
+
+				p := newPipeline(config,
+					logger.With("environment", environment),
+					shared,
+					envSelector,
+					filepath.Join(config.SrcDirPath, preview.Name("", environment)),
+					envInstaller,
+					history.NewRefStore(ref.String(), store))
+
+				return p, envInstaller
+			},
+			deployed: func(ctx context.Context) (map[string]install.Uninstaller, error) {
+				envInstallers, err := installer.Environments(ctx)
+				if err != nil {
+					return nil, err
+				}
+
+				uninstallers := make(map[string]install.Uninstaller, len(envInstallers))
+				for environment, envInstaller := range envInstallers {
+					uninstallers[environment] = envInstaller
+				}
+
+				return uninstallers, nil
+			},
+			triggerer:       triggerer,
+			health:          monitor,
+			metrics:         recorder,
+			logger:          componentLogger(logger, "supervisor"),
+			pollPeriod:      config.PollPeriod,
+			listTimeout:     config.CheckTimeout,
+			teardownTimeout: config.InstallTimeout + installGracePeriod,
+		}
+
+		// Run does not return until shutdown is requested
+		supervisor.run(ctx, done)
+		exitAfterShutdown(logger, ctx, received)
+		return
+	}
+
+	p := newPipeline(config, logger, shared, selector, config.SrcDirPath, installer, store)
+	p.triggerer = triggerer
+	p.metrics = recorder
+	p.health = monitor
+
+	if err := p.setup(ctx); err != nil {
+		if ctx.Err() != nil {
+			logger.Warn("setup cancelled", "error", err)
+			os.Exit(signalExitCode(<-received))
+		}
+
+		fatal(logger, "Setup error", err)
+	}
+
+	// Run does not return until shutdown is requested
+	p.run(ctx, done)
+	exitAfterShutdown(logger, ctx, received)
+}
+
+// exitAfterShutdown reports how shutdown went, exiting with the signal's status if it was
+// not clean. Shutdown is only clean if in-flight work did not have to be cancelled.
+func exitAfterShutdown(logger *slog.Logger, ctx context.Context, received <-chan os.Signal) {
+	sig := <-received
+	if ctx.Err() != nil {
+		logger.Warn("shut down, in-flight work was cancelled", "signal", sig.String())
+		os.Exit(signalExitCode(sig))
+	}
+
+	logger.Info("shut down cleanly", "signal", sig.String())
+}
+
+// sharedComponents are the components shared by every pipeline.
+type sharedComponents struct {
+	gitAuth    gitauth.Provider
+	builder    build.Builder
+	pusher     push.Pusher
+	pruner     build.Pruner          // May be nil, in which case nothing is pruned
+	imageCheck imagecheck.Checker    // May be nil, in which case the image is always built and pushed
+	archiver   logarchive.Archiver   // May be nil, in which case run output is not archived
+	pathFilter *filter.GitPathFilter // May be nil, in which case every change is built and installed
+}
+
+// newPipeline returns the pipeline which deploys the ref selected by the selector.
+// It is checked out into the source directory and installed by the installer.
+func newPipeline(config *config,
+	logger *slog.Logger,
+	shared *sharedComponents,
+	selector gitutil.RefSelector,
 	srcDirPath string,
-	imageName string,
-	installTimeout time.Duration) error {
-	if err := obtainer.Obtain(srcDirPath); err != nil {
+	installer *install.HelmK8sAtomicInstaller,
+	store history.Store) *pipeline {
+	gitAuth := shared.gitAuth
+	checkout := gitutil.CheckoutOptions{
+		Depth:       config.GitCloneDepth,
+		SparsePaths: config.GitSparsePaths,
+		Submodules:  config.GitSubmodules,
+		LFS:         config.GitLFS,
+	}
+	preparer := prepare.NewFilesystemPreparer(componentLogger(logger, "preparer"))
+	var obtainer obtain.Obtainer = obtain.NewGitCloneObtainer(config.GitRepoURL,
+		selector,
+		checkout,
+		gitAuth,
+		preparer,
+		componentLogger(logger, "obtainer"))
+	if config.ObtainMode == obtain.ModeFetch {
+		obtainer = obtain.NewGitFetchObtainer(config.GitRepoURL,
+			selector,
+			checkout,
+			gitAuth,
+			obtainer,
+			componentLogger(logger, "obtainer"))
+	}
+	// When tracking tags, images are tagged with the Git tag rather than the commit hash
+	var tagDeducer tagdeduce.TagDeducer = tagdeduce.NewGitHashTagDeducer(srcDirPath,
+		componentLogger(logger, "tagdeducer"))
+	if _, tracksTags := selector.(*gitutil.TagSelector); tracksTags {
+		tagDeducer = tagdeduce.NewGitTagTagDeducer(srcDirPath, selector, componentLogger(logger, "tagdeducer"))
+	}
+	// Images of pull requests are tagged apart from those of branches, as their commits
+	// may later be merged
+	if nameSelector, selectsName := selector.(*gitutil.RefNameSelector); selectsName {
+		if number, isPullRequest := gitutil.PullRequestNumber(nameSelector.Name); isPullRequest {
+			tagDeducer = tagdeduce.NewPrefixedTagDeducer(fmt.Sprintf("pr-%d", number), tagDeducer)
+		}
+	}
+	checker := check.NewGitChecker(srcDirPath,
+		selector,
+		config.GitSubmodules,
+		gitAuth,
+		componentLogger(logger, "checker"))
+	var updater obtain.Updater = obtain.NewGitPullUpdater(selector, checkout, gitAuth, componentLogger(logger, "updater"))
+	if config.UpdateStrategy == obtain.StrategyReset {
+		updater = obtain.NewGitResetUpdater(selector, checkout, gitAuth, componentLogger(logger, "updater"))
+	}
+	resolver := revision.NewGitHeadResolver(srcDirPath, componentLogger(logger, "resolver"))
+
+	p := &pipeline{
+		obtainer:   obtainer,
+		tagDeducer: tagDeducer,
+		builder:    shared.builder,
+		pusher:     shared.pusher,
+		pruner:     shared.pruner,
+		imageCheck: shared.imageCheck,
+		installer:  installer,
+		inspector:  installer,
+		checker:    checker,
+		updater:    updater,
+		resolver:   resolver,
+		ancestry:   resolver,
+		store:      store,
+		archiver:   shared.archiver,
+		logger:     componentLogger(logger, "pipeline"),
+
+		srcDirPath:     srcDirPath,
+		imageName:      config.ImageName,
+		pollPeriod:     config.PollPeriod,
+		installTimeout: config.InstallTimeout,
+		timeouts: stageTimeouts{
+			obtain: config.ObtainTimeout,
+			check:  config.CheckTimeout,
+			update: config.UpdateTimeout,
+			deduce: config.DeduceTimeout,
+			build:  config.BuildTimeout,
+			push:   config.PushTimeout,
+			prune:  config.PruneTimeout,
+			lookup: config.ImageCheckTimeout,
+		},
+	}
+	if shared.pathFilter != nil {
+		p.filter = shared.pathFilter.ForPath(srcDirPath)
+	}
+
+	return p
+}
+
+// componentLogger returns a logger which identifies the component logging.
+func componentLogger(logger *slog.Logger, component string) *slog.Logger {
+	return logger.With("component", component)
+}
+
+// checkModes checks that the obtain mode and update strategy are known.
+func checkModes(config *config) error {
+	switch config.ObtainMode {
+	case obtain.ModeClone, obtain.ModeFetch:
+	default:
+		return fmt.Errorf("unknown obtain mode %q", config.ObtainMode)
+	}
+
+	switch config.UpdateStrategy {
+	case obtain.StrategyPull, obtain.StrategyReset:
+	default:
+		return fmt.Errorf("unknown update strategy %q", config.UpdateStrategy)
+	}
+
+	return nil
+}
+
+// previewRefMatcher returns the matcher of the refs for which preview environments are
+// deployed, being the branches matching the branch pattern and the heads of pull requests,
+// or nil if neither is configured.
+func previewRefMatcher(config *config) (gitutil.RefMatcher, error) {
+	if config.GitBranchPattern == "" && !config.GitPullRequests {
+		return nil, nil
+	}
+
+	if config.GitBranch != "" || config.GitTagPattern != "" || config.GitTagConstraint != "" {
+		return nil, errors.New("a Git branch pattern or pull requests may not be set with a Git branch or tag pattern or constraint")
+	}
+
+	var matchers gitutil.AnyRefMatcher
+	if config.GitBranchPattern != "" {
+		branchMatcher, err := gitutil.NewBranchPatternMatcher(config.GitBranchPattern)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, branchMatcher)
+	}
+
+	if config.GitPullRequests {
+		matchers = append(matchers, gitutil.NewPullRequestMatcher())
+	}
+
+	return matchers, nil
+}
+
+// refSelector returns the selector of the Git ref to deploy, which is either the head of
+// the configured branch or the highest tag matching the configured pattern and constraint.
+func refSelector(config *config) (gitutil.RefSelector, error) {
+	tracksTags := config.GitTagPattern != "" || config.GitTagConstraint != ""
+	switch {
+	case config.GitBranch != "" && tracksTags:
+		return nil, errors.New("only one of a Git branch or tag pattern and constraint may be set")
+	case tracksTags:
+		return gitutil.NewTagSelector(config.GitTagPattern, config.GitTagConstraint)
+	case config.GitBranch != "":
+		return gitutil.NewBranchSelector(config.GitBranch), nil
+	default:
+		return nil, errors.New("either a Git branch, branch pattern, pull requests or tag pattern or constraint must be set")
+	}
+}
+
+// imageBackend is what builds images, pushes them and prunes what they leave behind.
+type imageBackend struct {
+	builder build.Builder
+	pusher  push.Pusher
+	pruner  build.Pruner // May be nil, in which case nothing is pruned
+}
+
+// newImageBackend returns the image backend of the configured builder kind, pruning
+// according to the configured policy if pruning is enabled.
+func newImageBackend(config *config, options build.Options, logger *slog.Logger) (*imageBackend, error) {
+	if err := options.CheckCaches(config.Builder); err != nil {
+		return nil, err
+	}
+
+	if err := options.CheckDockerfile(config.Builder); err != nil {
+		return nil, err
+	}
+
+	builderLogger := componentLogger(logger, "builder")
+	pusherLogger := componentLogger(logger, "pusher")
+	prunerLogger := componentLogger(logger, "pruner")
+	policy := build.PrunePolicy{
+		KeepDuration:  config.BuildPruneKeepAge,
+		KeepStorageMB: config.BuildPruneKeepMB,
+	}
+
+	backend := new(imageBackend)
+	switch config.Builder {
+	case build.KindDocker:
+		// The default image store of the Docker daemon can not hold images for more than one platform
+		if len(options.Platforms) > 1 {
+			return nil, fmt.Errorf("building for more than one platform is not supported by the %s builder", config.Builder)
+		}
+
+		backend.builder = build.NewDockerCLIBuilder(options, builderLogger)
+		backend.pusher = push.NewDockerCLIPusher(pusherLogger)
+		if config.BuildPrune {
+			backend.pruner = build.NewDockerCLIPruner(policy, prunerLogger)
+		}
+	case build.KindPodman, build.KindBuildah:
+		// Images for more than one platform are built as manifest lists, which are not tagged
+		if len(options.Platforms) > 1 {
+			return nil, fmt.Errorf("building for more than one platform is not supported by the %s builder", config.Builder)
+		}
+
+		backend.builder = build.NewCLIBuilder(config.Builder, options, builderLogger)
+		backend.pusher = push.NewCLIPusher(config.Builder, pusherLogger)
+		if config.BuildPrune {
+			newPruner := build.NewPodmanCLIPruner
+			if config.Builder == build.KindBuildah {
+				newPruner = build.NewBuildahCLIPruner
+			}
+
+			pruner, err := newPruner(policy, prunerLogger)
+			if err != nil {
+				return nil, err
+			}
+			backend.pruner = pruner
+		}
+	case build.KindBuildKit:
+		backend.builder = build.NewBuildKitCLIBuilder(options, builderLogger)
+		backend.pusher = push.NewBuildKitPusher(pusherLogger)
+		if config.BuildPrune {
+			backend.pruner = build.NewBuildKitCLIPruner(policy, prunerLogger)
+		}
+	case build.KindOCI:
+		if config.OCIBaseImage == "" {
+			return nil, errors.New("an OCI base image is required by the OCI builder")
+		}
+
+		layout, err := build.NewOCILayout(config.OCILayoutPath)
+		if err != nil {
+			return nil, err
+		}
+
+		builder, err := build.NewOCIBuilder(config.OCIBaseImage,
+			config.OCISourcePath,
+			config.OCIDestPath,
+			layout,
+			options,
+			builderLogger)
+		if err != nil {
+			return nil, err
+		}
+
+		backend.builder = builder
+		backend.pusher = push.NewOCIPusher(layout, pusherLogger)
+		if config.BuildPrune {
+			pruner, err := build.NewOCILayoutPruner(layout, policy, prunerLogger)
+			if err != nil {
+				return nil, err
+			}
+			backend.pruner = pruner
+		}
+	default:
+		return nil, fmt.Errorf("unknown builder %q", config.Builder)
+	}
+
+	return backend, nil
+}
+
+// gitAuthProvider returns the provider of authentication for the scheme of the Git repo URL.
+func gitAuthProvider(config *config) (gitauth.Provider, error) {
+	endpoint, err := transport.NewEndpoint(config.GitRepoURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Git repo URL: %w", gitauth.RedactError(err, nil, config.GitRepoURL))
+	}
+
+	if endpoint.Protocol == "ssh" {
+		return gitauth.NewSSHProvider(endpoint,
+			config.GitSSHKeyPath,
+			config.GitSSHKeyPassphrase,
+			config.GitSSHKnownHostsPath)
+	}
+
+	sources := 0
+	for _, source := range []string{config.GitPassword, config.GitPasswordFilePath, config.GitCredentialHelper} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New("only one of a Git password, password file or credential helper may be set")
+	}
+
+	switch {
+	case config.GitPassword != "":
+		return gitauth.NewBasicAuthProvider(config.GitUsername, config.GitPassword), nil
+	case config.GitPasswordFilePath != "":
+		return gitauth.NewFileProvider(config.GitUsername, config.GitPasswordFilePath), nil
+	case config.GitCredentialHelper != "":
+		return gitauth.NewCredentialHelperProvider(config.GitCredentialHelper, endpoint), nil
+	}
+
+	// Any credentials embedded within the URL are used by the Git client
+	return gitauth.NewAnonymousProvider(), nil
+}
+
+// fatal logs the error and exits with a failure status.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// handleSignals closes the done channel upon the first SIGINT or SIGTERM, and sends the
+// signal on the returned channel. If a second signal is received, or the shutdown timeout
+// expires, the cancel function is called to cancel in-flight work.
+func handleSignals(logger *slog.Logger,
+	done chan<- struct{},
+	cancel context.CancelFunc,
+	shutdownTimeout time.Duration) <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	received := make(chan os.Signal, 1)
+
+	go func() {
+		sig := <-signals
+		logger.Info("shutting down after in-flight work completes",
+			"signal", sig.String(),
+			"timeout", shutdownTimeout)
+		received <- sig
+		close(done)
+
+		select {
+		case sig = <-signals:
+			logger.Warn("signal received again, cancelling in-flight work", "signal", sig.String())
+		case <-time.After(shutdownTimeout):
+			logger.Warn("shutdown timeout expired, cancelling in-flight work")
+		}
+		cancel()
+	}()
+
+	return received
+}
+
+// signalExitCode returns the conventional exit code for a process terminated by a signal.
+func signalExitCode(sig os.Signal) int {
+	if sig, ok := sig.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+
+	return 1
+}
+
+// installGracePeriod is added to the install timeout given to the installer to form the
+// deadline of the install stage, so the installer has time to roll back after timing out.
+const installGracePeriod = time.Minute
+
+// stageTimeouts are the deadlines for each stage of the pipeline.
+// A zero timeout means the stage has no deadline.
+type stageTimeouts struct {
+	obtain time.Duration
+	check  time.Duration
+	update time.Duration
+	deduce time.Duration
+	build  time.Duration
+	push   time.Duration
+	prune  time.Duration
+	lookup time.Duration // The deadline for checking whether the image has already been pushed
+}
+
+// pipeline holds the stages which obtain, check, build and install the source code,
+// and their configuration.
+type pipeline struct {
+	obtainer   obtain.Obtainer
+	tagDeducer tagdeduce.TagDeducer
+	builder    build.Builder
+	pusher     push.Pusher
+	pruner     build.Pruner       // May be nil, in which case nothing is pruned
+	imageCheck imagecheck.Checker // May be nil, in which case the image is always built and pushed
+	installer  install.Installer
+	inspector  install.Inspector
+	checker    check.Checker
+	updater    obtain.Updater
+	triggerer  trigger.Triggerer // May be nil, in which case only polling is used
+	resolver   revision.Resolver
+	ancestry   revision.AncestryChecker // May be nil, in which case history rewrites are not detected
+	filter     filter.Filter            // May be nil, in which case every change is built and installed
+	store      history.Store
+	archiver   logarchive.Archiver // May be nil, in which case run output is not archived
+	metrics    metrics.Recorder    // May be nil, in which case no metrics are recorded
+	health     health.Reporter     // May be nil, in which case health is not reported
+	logger     *slog.Logger
+
+	srcDirPath     string
+	imageName      string
+	pollPeriod     time.Duration // Zero means changes are only checked for when triggered
+	installTimeout time.Duration
+	timeouts       stageTimeouts
+}
+
+func (p *pipeline) setup(ctx context.Context) error {
+	obtainCtx, cancel := withTimeout(ctx, p.timeouts.obtain)
+	defer cancel()
+	if err := p.obtainer.Obtain(obtainCtx, p.srcDirPath); err != nil {
 		return fmt.Errorf("obtaining source code: %w", err)
 	}
 
+	// Skip the initial build and installation if the obtained revision is already installed,
+	// so that restarts do not needlessly rebuild, push and upgrade.
+	record := history.NewRun(history.ReasonSetup, p.imageName)
+	ctx = logging.WithAttrs(ctx, slog.String("run_id", record.ID))
+	installed, err := p.isInstalled(ctx, record)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error determining if revision is already installed", "error", err)
+	} else if installed {
+		p.logger.InfoContext(ctx, "revision is already installed, skipping initial build and install",
+			"commit", record.Commit,
+			"tag", record.Tag)
+		record.Skip()
+		if err := p.store.Record(record); err != nil {
+			p.logger.WarnContext(ctx, "error recording pipeline run", "error", err)
+		}
+		if p.metrics != nil {
+			p.metrics.Installed(record.Commit)
+		}
+
+		p.ready()
+		return nil
+	}
+
 	// Initial build and installation
-	if err := buildAndInstall(tagDeducer,
-		builder,
-		pusher,
-		installer,
-		srcDirPath,
-		imageName,
-		installTimeout); err != nil {
+	err = p.buildAndInstall(ctx, record)
+	p.recordRun(ctx, record, err)
+	if err != nil {
 		return fmt.Errorf("performing initial build and install: %w", err)
 	}
 
+	p.ready()
 	return nil
 }
 
-func run(tagDeducer tagdeduce.TagDeducer,
-	builder build.Builder,
-	pusher push.Pusher,
-	installer install.Installer,
-	checker check.Checker,
-	updater obtain.Updater,
-	srcDirPath string,
-	imageName string,
-	pollPeriod time.Duration,
-	installTimeout time.Duration,
-	done <-chan struct{}) {
+func (p *pipeline) run(ctx context.Context, done <-chan struct{}) {
+	// Receiving from a nil channel blocks forever, so if there is no triggerer,
+	// the trigger case will never be selected.
+	var triggered <-chan struct{}
+	if p.triggerer != nil {
+		triggered = p.triggerer.Triggered()
+	}
+
 	// Check for changes, build and install them.
 	// If the done channel is closed, stop polling and return.
 	for {
+		// Reaching here means the previous iteration, if any, has completed
+		p.beat()
+
 		select {
 		case <-done:
 			return
 		default:
 		}
 
-		time.Sleep(pollPeriod)
+		// Wait for the next poll, or check early if triggered. Without a poll period, only
+		// a trigger causes a check, which may never come.
+		var polled <-chan time.Time
+		if p.pollPeriod > 0 {
+			polled = time.After(p.pollPeriod)
+		} else if p.health != nil {
+			p.health.Wait(time.Time{})
+		}
 
-		hasChanged, err := checker.Check()
+		select {
+		case <-done:
+			return
+		case <-polled:
+		case <-triggered:
+			p.logger.InfoContext(ctx, "change notification received, checking for changes")
+		}
+
+		hasChanged, err := p.check(ctx)
 		if err != nil {
 			// If there is an error, try again next time
-			log.Printf("Warning: Error checking for changes: %v", err)
+			p.logger.WarnContext(ctx, "error checking for changes", "error", err)
 			continue
 		}
 
 		if hasChanged {
-			if err := updater.Update(srcDirPath); err != nil {
+			record := history.NewRun(history.ReasonChange, p.imageName)
+			runCtx := logging.WithAttrs(ctx, slog.String("run_id", record.ID))
+
+			if err := p.update(runCtx, record); err != nil {
 				// If there is an error, try again next time
-				log.Printf("Warning: Error updating to obtain latest changes: %v", err)
+				p.logger.WarnContext(runCtx, "error updating to obtain latest changes", "error", err)
+				continue
+			}
+
+			// The change is still recorded if skipped, and is not checked for again as it
+			// has been checked out
+			if !p.isRelevant(runCtx, record) {
+				p.logger.InfoContext(runCtx, "skipping build and install", "commit", record.Commit)
+				record.Skip()
+				if err := p.store.Record(record); err != nil {
+					p.logger.WarnContext(runCtx, "error recording pipeline run", "error", err)
+				}
 				continue
 			}
 
-			if err := buildAndInstall(tagDeducer,
-				builder,
-				pusher,
-				installer,
-				srcDirPath,
-				imageName,
-				installTimeout); err != nil {
+			err := p.buildAndInstall(runCtx, record)
+			p.recordRun(runCtx, record, err)
+			if err != nil {
 				// If there is an error, the installer must deal with it and leave the app in a
 				// working state. Therefore, we await the next change which may fix the error.
-				log.Printf("Warning: Error performing build and install due to change: %v", err)
+				p.logger.WarnContext(runCtx, "error performing build and install due to change", "error", err)
 				continue
 			}
 		}
 	}
 }
 
-func buildAndInstall(tagDeducer tagdeduce.TagDeducer,
-	builder build.Builder,
-	pusher push.Pusher,
-	installer install.Installer,
-	srcDirPath string,
-	imageName string,
-	installTimeout time.Duration) error {
+func (p *pipeline) ready() {
+	if p.health != nil {
+		p.health.Ready()
+	}
+}
+
+func (p *pipeline) beat() {
+	if p.health != nil {
+		p.health.Beat()
+	}
+}
+
+// waitFor reports that the pipeline is waiting for a stage, which may run until the
+// deadline of its context, so that it is not deemed stuck while the stage runs for long.
+func (p *pipeline) waitFor(ctx context.Context) {
+	if p.health != nil {
+		deadline, _ := ctx.Deadline()
+		p.health.Wait(deadline)
+	}
+}
+
+func (p *pipeline) check(ctx context.Context) (bool, error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.check)
+	defer cancel()
+	p.waitFor(ctx)
+
+	changed, err := p.checker.Check(ctx)
+	if p.metrics != nil {
+		p.metrics.Checked(changed, err)
+	}
+
+	return changed, err
+}
+
+// update obtains the latest changes. If the commit checked out beforehand is not an
+// ancestor of the one checked out afterwards, the history rewrite is set on the record.
+func (p *pipeline) update(ctx context.Context, record *history.Run) error {
+	previous, err := p.resolver.Resolve(ctx)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error resolving revision before update", "error", err)
+	}
+	record.PreviousCommit = previous
+
+	updateCtx, cancel := withTimeout(ctx, p.timeouts.update)
+	defer cancel()
+	p.waitFor(updateCtx)
+	if err := p.updater.Update(updateCtx, p.srcDirPath); err != nil {
+		return err
+	}
+
+	if p.ancestry == nil || previous == "" {
+		return nil
+	}
+
+	current, err := p.resolver.Resolve(ctx)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error resolving revision after update", "error", err)
+		return nil
+	}
+
+	isAncestor, err := p.ancestry.IsAncestor(ctx, previous, current)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error determining if history was rewritten", "error", err)
+		return nil
+	}
+
+	if !isAncestor {
+		p.logger.WarnContext(ctx, "history rewritten, previous commit is not an ancestor",
+			"previous_commit", previous,
+			"commit", current)
+		record.HistoryRewritten = true
+	}
+
+	return nil
+}
+
+// isRelevant determines if the change from the commit last processed to the one checked
+// out now is to be built and installed. A commit is processed once deployed, or once
+// skipped as no relevant paths changed since the commit processed before it, whose image
+// then remains installed. The change is not taken from the commit checked out before the
+// update, as that may have failed to deploy, and its changes would be lost if the next
+// change was skipped. If it cannot be determined, the change is considered relevant.
+// The resolved commit is set on the record, as is the tag of the image which remains
+// installed if the change is not relevant.
+func (p *pipeline) isRelevant(ctx context.Context, record *history.Run) bool {
+	if p.filter == nil {
+		return true
+	}
+
+	lastRun, err := p.store.LastProcessed()
+	if err != nil {
+		p.logger.WarnContext(ctx, "error reading last processed run to filter change", "error", err)
+		return true
+	}
+
+	if lastRun == nil || lastRun.Commit == "" {
+		return true
+	}
+
+	commit, err := p.resolver.Resolve(ctx)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error resolving revision to filter change", "error", err)
+		return true
+	}
+	record.Commit = commit
+
+	relevant, err := p.filter.Relevant(ctx, lastRun.Commit, commit)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error filtering change, treating as relevant", "error", err)
+		return true
+	}
+
+	if !relevant {
+		p.logger.InfoContext(ctx, "no relevant paths changed since commit last processed",
+			"processed_commit", lastRun.Commit,
+			"commit", commit)
+		record.Tag = lastRun.Tag
+	}
+
+	return relevant
+}
 
-	tag, err := tagDeducer.Deduce()
+// isInstalled determines if the revision checked out is the one currently installed.
+// The installer is asked what is installed, falling back to the last processed run in
+// the history if it cannot answer. A revision whose change was skipped as not relevant
+// is installed if the image left installed upon skipping it still is. The resolved commit
+// and tag are set on the record.
+func (p *pipeline) isInstalled(ctx context.Context, record *history.Run) (bool, error) {
+	commit, err := p.resolver.Resolve(ctx)
 	if err != nil {
+		return false, fmt.Errorf("resolving revision: %w", err)
+	}
+	record.Commit = commit
+
+	deduceCtx, cancel := withTimeout(ctx, p.timeouts.deduce)
+	defer cancel()
+	tag, err := p.tagDeducer.Deduce(deduceCtx)
+	if err != nil {
+		return false, fmt.Errorf("deducing tag: %w", err)
+	}
+
+	lastRun, lastErr := p.store.LastProcessed()
+	if lastErr == nil && lastRun != nil && lastRun.Outcome == history.OutcomeSkipped &&
+		lastRun.Commit == commit && lastRun.Tag != "" {
+		tag = lastRun.Tag
+	}
+	record.Tag = tag
+
+	installedImageName, installedTag, err := p.inspector.Installed(ctx)
+	if err == nil {
+		return installedImageName == p.imageName && installedTag == tag, nil
+	}
+	p.logger.WarnContext(ctx, "error getting installed image, falling back to history", "error", err)
+
+	if lastErr != nil {
+		return false, fmt.Errorf("getting last processed run: %w", lastErr)
+	}
+
+	return lastRun != nil &&
+		lastRun.ImageName == p.imageName &&
+		lastRun.Commit == commit &&
+		lastRun.Tag == tag, nil
+}
+
+func (p *pipeline) buildAndInstall(ctx context.Context, record *history.Run) error {
+	commit, err := p.resolver.Resolve(ctx)
+	if err != nil {
+		return fmt.Errorf("resolving revision: %w", err)
+	}
+	record.Commit = commit
+	ctx = logging.WithAttrs(ctx, slog.String("commit", commit))
+
+	runLog := p.openRunLog(ctx, record)
+	if runLog != nil {
+		defer func() {
+			if err := runLog.Close(); err != nil {
+				p.logger.WarnContext(ctx, "error closing run log", "error", err)
+			}
+		}()
+	}
+
+	var tag string
+	if err := p.timeStage(ctx, record, runLog, "deduce", p.timeouts.deduce, func(ctx context.Context) (err error) {
+		tag, err = p.tagDeducer.Deduce(ctx)
+		return err
+	}); err != nil {
 		return fmt.Errorf("deducing tag: %w", err)
 	}
+	record.Tag = tag
+
+	if p.imageExists(ctx, tag) {
+		p.logger.InfoContext(ctx, "image already in registry, skipping build and push", "tag", tag)
+		record.ImageExisted = true
+	} else if err := p.buildAndPush(ctx, record, runLog, tag, commit); err != nil {
+		return err
+	}
+
+	installDeadline := time.Duration(0)
+	if p.installTimeout > 0 {
+		installDeadline = p.installTimeout + installGracePeriod
+	}
+	if err := p.timeStage(ctx, record, runLog, "install", installDeadline, func(ctx context.Context) error {
+		return p.installer.Install(ctx, p.imageName, tag, p.installTimeout)
+	}); err != nil {
+		return fmt.Errorf("installing image: %w", err)
+	}
+
+	return nil
+}
 
-	if err := builder.Build(srcDirPath, imageName, tag); err != nil {
+// buildAndPush builds the image of the tag and pushes it.
+func (p *pipeline) buildAndPush(ctx context.Context,
+	record *history.Run,
+	runLog *logarchive.RunLog,
+	tag, commit string) error {
+	// Whatever the outcome, what the build leaves behind is pruned once the run is done
+	defer p.prune(ctx)
+
+	if err := p.timeStage(ctx, record, runLog, "build", p.timeouts.build, func(ctx context.Context) error {
+		return p.builder.Build(ctx, p.srcDirPath, p.imageName, tag, commit)
+	}); err != nil {
 		return fmt.Errorf("building image: %w", err)
 	}
 
-	if err := pusher.Push(imageName, tag); err != nil {
+	if err := p.timeStage(ctx, record, runLog, "push", p.timeouts.push, func(ctx context.Context) error {
+		return p.pusher.Push(ctx, p.imageName, tag)
+	}); err != nil {
 		return fmt.Errorf("pushing image: %w", err)
 	}
 
-	if err := installer.Install(imageName, tag, installTimeout); err != nil {
-		return fmt.Errorf("installing image: %w", err)
+	return nil
+}
+
+// imageExists returns whether the image of the tag has already been pushed, if an image
+// checker is configured. If it can not be checked, the image is assumed not to exist, so
+// that it is built and pushed.
+func (p *pipeline) imageExists(ctx context.Context, tag string) bool {
+	if p.imageCheck == nil {
+		return false
 	}
 
-	return nil
+	checkCtx, cancel := withTimeout(ctx, p.timeouts.lookup)
+	defer cancel()
+	exists, err := p.imageCheck.Exists(checkCtx, p.imageName, tag)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error checking for image in registry, building it", "error", err)
+		return false
+	}
+
+	return exists
+}
+
+// prune prunes the build host, if a pruner is configured. As pruning is housekeeping, an
+// error pruning does not fail the run.
+func (p *pipeline) prune(ctx context.Context) {
+	if p.pruner == nil {
+		return
+	}
+
+	pruneCtx, cancel := withTimeout(ctx, p.timeouts.prune)
+	defer cancel()
+	if err := p.pruner.Prune(pruneCtx); err != nil {
+		p.logger.WarnContext(ctx, "error pruning build host", "error", err)
+	}
+}
+
+// openRunLog opens the archive of the run's output, returning nil if the output is not
+// to be archived. Failure to open the archive is not fatal, as it is not needed to build
+// and install.
+func (p *pipeline) openRunLog(ctx context.Context, record *history.Run) *logarchive.RunLog {
+	if p.archiver == nil {
+		return nil
+	}
+
+	runLog, err := p.archiver.Open(record.Commit, record.StartedAt)
+	if err != nil {
+		p.logger.WarnContext(ctx, "error opening run log, output will not be archived", "error", err)
+		return nil
+	}
+	record.LogPath = runLog.Path
+
+	return runLog
+}
+
+// timeStage runs a stage of the pipeline with the given timeout, recording it in the run record
+// and archiving its output to the run log, if there is one.
+func (p *pipeline) timeStage(ctx context.Context,
+	record *history.Run,
+	runLog *logarchive.RunLog,
+	name string,
+	timeout time.Duration,
+	stage func(ctx context.Context) error) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	p.waitFor(ctx)
+
+	// Identify everything logged by the stage
+	ctx = logging.WithAttrs(ctx, slog.String("stage", name))
+	if runLog != nil {
+		ctx = executil.WithOutput(ctx, runLog.Stage(name))
+	}
+
+	err := record.TimeStage(name, func() error {
+		return stage(ctx)
+	})
+	if p.metrics != nil {
+		stageRecord := record.Stages[len(record.Stages)-1]
+		p.metrics.Staged(name, stageRecord.Duration, err)
+	}
+
+	return err
+}
+
+// recordRun completes the record of a pipeline run and persists it.
+// Failure to persist is not fatal, as the pipeline itself has already completed.
+func (p *pipeline) recordRun(ctx context.Context, record *history.Run, err error) {
+	if err != nil && ctx.Err() != nil {
+		record.Cancel(err)
+	} else {
+		record.Finish(err)
+	}
+
+	if err := p.store.Record(record); err != nil {
+		p.logger.WarnContext(ctx, "error recording pipeline run", "error", err)
+	}
+
+	if record.Outcome == history.OutcomeSucceeded && p.metrics != nil {
+		p.metrics.Deployed(record.Commit)
+	}
+}
+
+// withTimeout returns a context with the given timeout, or without a deadline if the
+// timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
 }
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/jhwbarlow/mockcicd/pkg/build"
+	"github.com/jhwbarlow/mockcicd/pkg/history"
+	"github.com/jhwbarlow/mockcicd/pkg/logarchive"
+	"github.com/jhwbarlow/mockcicd/pkg/logging"
 )
 
 func TestSetupBuildsAndInstalls(t *testing.T) {
@@ -17,14 +28,22 @@ func TestSetupBuildsAndInstalls(t *testing.T) {
 	mockImageName := ""
 	mockInstallTimeout := time.Duration(0)
 
-	err := setup(mockObtainer,
-		mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockSrcDirPath,
-		mockImageName,
-		mockInstallTimeout)
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       mockObtainer,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		inspector:      newMockInspector("", ""),
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		installTimeout: mockInstallTimeout,
+	}
+
+	err := p.setup(context.Background())
 
 	if err != nil {
 		t.Errorf("expected nil error, got %q (of type %T)", err, err)
@@ -60,14 +79,22 @@ func TestSetupErrorsUponObtainerError(t *testing.T) {
 	mockImageName := ""
 	mockInstallTimeout := time.Duration(0)
 
-	err := setup(mockObtainer,
-		mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockSrcDirPath,
-		mockImageName,
-		mockInstallTimeout)
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       mockObtainer,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		inspector:      newMockInspector("", ""),
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		installTimeout: mockInstallTimeout,
+	}
+
+	err := p.setup(context.Background())
 
 	if err == nil {
 		t.Error("expected error, got nil")
@@ -96,17 +123,23 @@ func TestRunBuildsAndInstallsUponChange(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the new release to be "installed", so that the done channel is not closed too early
 	<-installed
@@ -154,17 +187,23 @@ func TestRunSkipsBuildAndInstallUponNoChange(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the checker to "check", so that the done channel is not closed too early
 	<-checked
@@ -214,17 +253,23 @@ func TestRunContinuesUponInstallError(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the install attempts count to be reached, so that the done channel is not closed too early
 	<-installCountReached
@@ -309,17 +354,23 @@ func TestRunContinuesUponPushError(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the push attempts count to be reached, so that the done channel is not closed too early
 	<-pushCountReached
@@ -399,17 +450,23 @@ func TestRunContinuesUponBuildError(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the build attempts count to be reached, so that the done channel is not closed too early
 	<-buildCountReached
@@ -488,17 +545,23 @@ func TestRunContinuesUponTagDeduceError(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the tag deduce attempts count to be reached, so that the done channel is not closed too early
 	<-deduceCountReached
@@ -568,17 +631,23 @@ func TestRunContinuesUponUpdateError(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the update attempts count to be reached, so that the done channel is not closed too early
 	<-updateCountReached
@@ -643,17 +712,23 @@ func TestRunContinuesUponCheckError(t *testing.T) {
 	pollPeriodDuration := time.Nanosecond
 	done := make(chan struct{})
 
-	go run(mockTagDeducer,
-		mockBuilder,
-		mockPusher,
-		mockInstaller,
-		mockChecker,
-		mockUpdater,
-		mockSrcDirPath,
-		mockImageName,
-		pollPeriodDuration,
-		mockInstallTimeout,
-		done)
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
 
 	// Wait for the update attempts count to be reached, so that the done channel is not closed too early
 	<-checkCountReached
@@ -694,3 +769,1144 @@ func TestRunContinuesUponCheckError(t *testing.T) {
 		t.Error("expected Installer.Install() to not be called, but was")
 	}
 }
+
+func TestRunChecksUponTrigger(t *testing.T) {
+	mockTag := "mocktag"
+	mockTagDeducer := newMockTagDeducer(mockTag)
+	mockBuilder := newMockBuilder()
+	mockPusher := newMockPusher()
+	mockInstaller := newMockInstaller()
+	checked := make(chan struct{})
+	checkAcked := make(chan struct{})
+	mockChecker := newMockAsyncChecker(false, checked, checkAcked)
+	mockUpdater := newMockUpdater()
+	mockTriggerer := newMockTriggerer()
+	mockSrcDirPath := ""
+	mockImageName := ""
+	mockInstallTimeout := time.Duration(0)
+	pollPeriodDuration := time.Hour // Long enough that only the trigger can cause a check
+	done := make(chan struct{})
+
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		checker:        mockChecker,
+		updater:        mockUpdater,
+		triggerer:      mockTriggerer,
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     mockSrcDirPath,
+		imageName:      mockImageName,
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: mockInstallTimeout,
+	}
+
+	go p.run(context.Background(), done)
+
+	mockTriggerer.trigger()
+
+	// Wait for the checker to "check", so that the done channel is not closed too early
+	select {
+	case <-checked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Checker.Check() to be called upon trigger, but was not")
+	}
+
+	// Stop the run() goroutine from running forever
+	close(done)
+
+	// Signal to mock checker it is OK to continue
+	close(checkAcked)
+
+	if !mockChecker.checkCalled {
+		t.Error("expected Checker.Check() to be called, but was not")
+	}
+}
+
+func TestRunOnlyChecksUponTriggerWithoutPollPeriod(t *testing.T) {
+	checked := make(chan struct{})
+	checkAcked := make(chan struct{})
+	mockChecker := newMockAsyncChecker(false, checked, checkAcked)
+	mockTriggerer := newMockTriggerer()
+	mockHealthReporter := newMockHealthReporter()
+	done := make(chan struct{})
+
+	p := &pipeline{
+		logger:    discardLogger,
+		checker:   mockChecker,
+		triggerer: mockTriggerer,
+		store:     newMockStore(),
+		health:    mockHealthReporter,
+	}
+
+	go p.run(context.Background(), done)
+
+	select {
+	case <-checked:
+		t.Fatal("expected Checker.Check() not to be called without trigger, but was")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	mockTriggerer.trigger()
+
+	select {
+	case <-checked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Checker.Check() to be called upon trigger, but was not")
+	}
+
+	// Stop the run() goroutine from running forever
+	close(done)
+
+	// Signal to mock checker it is OK to continue
+	close(checkAcked)
+
+	// Waiting for the trigger is reported as without deadline, so that it is not deemed stuck
+	mockHealthReporter.mu.Lock()
+	defer mockHealthReporter.mu.Unlock()
+	if len(mockHealthReporter.deadlines) == 0 || !mockHealthReporter.deadlines[0].IsZero() {
+		t.Errorf("expected waiting without deadline to be reported, got %v", mockHealthReporter.deadlines)
+	}
+}
+
+func TestSetupRecordsSuccessfulRun(t *testing.T) {
+	mockObtainer := newMockObtainer(nil)
+	mockTag := "mocktag"
+	mockTagDeducer := newMockTagDeducer(mockTag)
+	mockResolver := newMockResolver()
+	mockStore := newMockStore()
+	mockImageName := "mockimage"
+
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       mockObtainer,
+		tagDeducer:     mockTagDeducer,
+		builder:        newMockBuilder(),
+		pusher:         newMockPusher(),
+		installer:      newMockInstaller(),
+		inspector:      newMockInspector("", ""),
+		resolver:       mockResolver,
+		store:          mockStore,
+		srcDirPath:     "",
+		imageName:      mockImageName,
+		installTimeout: time.Duration(0),
+	}
+
+	err := p.setup(context.Background())
+
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	records := mockStore.recorded()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 run to be recorded, got %d", len(records))
+	}
+
+	record := records[0]
+	if record.Outcome != history.OutcomeSucceeded {
+		t.Errorf("expected outcome %q, got %q", history.OutcomeSucceeded, record.Outcome)
+	}
+	if record.Reason != history.ReasonSetup {
+		t.Errorf("expected reason %q, got %q", history.ReasonSetup, record.Reason)
+	}
+	if record.Commit != mockResolver.revisionToReturn {
+		t.Errorf("expected commit %q, got %q", mockResolver.revisionToReturn, record.Commit)
+	}
+	if record.Tag != mockTag {
+		t.Errorf("expected tag %q, got %q", mockTag, record.Tag)
+	}
+	if record.ImageName != mockImageName {
+		t.Errorf("expected image name %q, got %q", mockImageName, record.ImageName)
+	}
+
+	expectedStages := []string{"deduce", "build", "push", "install"}
+	if len(record.Stages) != len(expectedStages) {
+		t.Fatalf("expected %d stages to be recorded, got %d", len(expectedStages), len(record.Stages))
+	}
+	for i, stage := range record.Stages {
+		if stage.Name != expectedStages[i] {
+			t.Errorf("expected stage %d to be %q, got %q", i, expectedStages[i], stage.Name)
+		}
+	}
+}
+
+func TestSetupRecordsFailedRun(t *testing.T) {
+	mockError := errors.New("mock builder error")
+	mockBuilder := newMockErroringBuilder(mockError)
+	mockPusher := newMockPusher()
+	mockStore := newMockStore()
+
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       newMockObtainer(nil),
+		tagDeducer:     newMockTagDeducer("mocktag"),
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      newMockInstaller(),
+		inspector:      newMockInspector("", ""),
+		resolver:       newMockResolver(),
+		store:          mockStore,
+		srcDirPath:     "",
+		imageName:      "",
+		installTimeout: time.Duration(0),
+	}
+
+	err := p.setup(context.Background())
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error %q, got error %q (of type %T)", mockError, err, err)
+	}
+
+	if mockPusher.pushCalled {
+		t.Error("expected Pusher.Push() to not be called, but was")
+	}
+
+	records := mockStore.recorded()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 run to be recorded, got %d", len(records))
+	}
+
+	record := records[0]
+	if record.Outcome != history.OutcomeFailed {
+		t.Errorf("expected outcome %q, got %q", history.OutcomeFailed, record.Outcome)
+	}
+	if record.Error == "" {
+		t.Error("expected error text to be recorded, but was empty")
+	}
+
+	lastStage := record.Stages[len(record.Stages)-1]
+	if lastStage.Name != "build" || lastStage.Error == "" {
+		t.Errorf("expected failed build stage to be recorded last, got %+v", lastStage)
+	}
+}
+
+func TestSetupSkipsBuildAndInstallWhenAlreadyInstalled(t *testing.T) {
+	mockTag := "mocktag"
+	mockImageName := "mockimage"
+	mockTagDeducer := newMockTagDeducer(mockTag)
+	mockBuilder := newMockBuilder()
+	mockPusher := newMockPusher()
+	mockInstaller := newMockInstaller()
+	mockInspector := newMockInspector(mockImageName, mockTag)
+	mockStore := newMockStore()
+
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       newMockObtainer(nil),
+		tagDeducer:     mockTagDeducer,
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      mockInstaller,
+		inspector:      mockInspector,
+		resolver:       newMockResolver(),
+		store:          mockStore,
+		srcDirPath:     "",
+		imageName:      mockImageName,
+		installTimeout: time.Duration(0),
+	}
+
+	err := p.setup(context.Background())
+
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockInspector.installedCalled {
+		t.Error("expected Inspector.Installed() to be called, but was not")
+	}
+	if mockBuilder.buildCalled {
+		t.Error("expected Builder.Build() to not be called, but was")
+	}
+	if mockPusher.pushCalled {
+		t.Error("expected Pusher.Push() to not be called, but was")
+	}
+	if mockInstaller.installCalled {
+		t.Error("expected Installer.Install() to not be called, but was")
+	}
+
+	records := mockStore.recorded()
+	if len(records) != 1 || records[0].Outcome != history.OutcomeSkipped {
+		t.Errorf("expected a single skipped run to be recorded, got %+v", records)
+	}
+}
+
+func TestSetupSkipsBuildAndInstallWhenImageOfSkippedRevisionInstalled(t *testing.T) {
+	mockImageName := "mockimage"
+	mockInspectorError := errors.New("mock inspector error")
+	tests := []struct {
+		name          string
+		errorToReturn error
+	}{
+		{"inspector", nil},
+		{"history fallback", mockInspectorError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The revision was skipped as no relevant paths changed, leaving the image of
+			// the commit deployed before it installed
+			mockResolver := newMockResolver()
+			mockInspector := newMockInspector(mockImageName, "mockdeployedtag")
+			mockInspector.errorToReturn = test.errorToReturn
+			mockStore := newMockStore()
+			mockStore.records = []history.Run{
+				{ImageName: mockImageName, Commit: "mockdeployed", Tag: "mockdeployedtag", Outcome: history.OutcomeSucceeded},
+				{ImageName: mockImageName, Commit: mockResolver.revisionToReturn, Tag: "mockdeployedtag", Outcome: history.OutcomeSkipped},
+			}
+			mockBuilder := newMockBuilder()
+
+			p := &pipeline{
+				logger:     discardLogger,
+				obtainer:   newMockObtainer(nil),
+				tagDeducer: newMockTagDeducer("mocktag"),
+				builder:    mockBuilder,
+				pusher:     newMockPusher(),
+				installer:  newMockInstaller(),
+				inspector:  mockInspector,
+				resolver:   mockResolver,
+				store:      mockStore,
+				imageName:  mockImageName,
+			}
+
+			if err := p.setup(context.Background()); err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if mockBuilder.buildCalled {
+				t.Error("expected Builder.Build() to not be called, but was")
+			}
+
+			records := mockStore.recorded()
+			if last := records[len(records)-1]; last.Outcome != history.OutcomeSkipped || last.Tag != "mockdeployedtag" {
+				t.Errorf("expected skipped run with tag %q to be recorded, got %+v", "mockdeployedtag", last)
+			}
+		})
+	}
+}
+
+func TestSetupBuildsAndInstallsWhenDifferentTagInstalled(t *testing.T) {
+	mockImageName := "mockimage"
+	mockBuilder := newMockBuilder()
+	mockInstaller := newMockInstaller()
+
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       newMockObtainer(nil),
+		tagDeducer:     newMockTagDeducer("mocktag"),
+		builder:        mockBuilder,
+		pusher:         newMockPusher(),
+		installer:      mockInstaller,
+		inspector:      newMockInspector(mockImageName, "oldtag"),
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     "",
+		imageName:      mockImageName,
+		installTimeout: time.Duration(0),
+	}
+
+	err := p.setup(context.Background())
+
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockBuilder.buildCalled {
+		t.Error("expected Builder.Build() to be called, but was not")
+	}
+	if !mockInstaller.installCalled {
+		t.Error("expected Installer.Install() to be called, but was not")
+	}
+}
+
+func TestSetupFallsBackToHistoryUponInspectorError(t *testing.T) {
+	mockTag := "mocktag"
+	mockImageName := "mockimage"
+	mockResolver := newMockResolver()
+	mockInspector := newMockInspector("", "")
+	mockInspector.errorToReturn = errors.New("mock inspector error")
+	mockStore := newMockStore()
+	mockStore.Record(&history.Run{
+		ImageName: mockImageName,
+		Commit:    mockResolver.revisionToReturn,
+		Tag:       mockTag,
+		Outcome:   history.OutcomeSucceeded,
+	})
+	mockBuilder := newMockBuilder()
+
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       newMockObtainer(nil),
+		tagDeducer:     newMockTagDeducer(mockTag),
+		builder:        mockBuilder,
+		pusher:         newMockPusher(),
+		installer:      newMockInstaller(),
+		inspector:      mockInspector,
+		resolver:       mockResolver,
+		store:          mockStore,
+		srcDirPath:     "",
+		imageName:      mockImageName,
+		installTimeout: time.Duration(0),
+	}
+
+	err := p.setup(context.Background())
+
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockBuilder.buildCalled {
+		t.Error("expected Builder.Build() to not be called, but was")
+	}
+}
+
+func TestSetupRecordsCancelledRunUponCancellation(t *testing.T) {
+	building := make(chan struct{})
+	mockBuilder := newMockBlockingBuilder(building)
+	mockPusher := newMockPusher()
+	mockStore := newMockStore()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Cancel the in-flight build once it has started
+	go func() {
+		<-building
+		cancel()
+	}()
+
+	p := &pipeline{
+		logger:         discardLogger,
+		obtainer:       newMockObtainer(nil),
+		tagDeducer:     newMockTagDeducer("mocktag"),
+		builder:        mockBuilder,
+		pusher:         mockPusher,
+		installer:      newMockInstaller(),
+		inspector:      newMockInspector("", ""),
+		resolver:       newMockResolver(),
+		store:          mockStore,
+		srcDirPath:     "",
+		imageName:      "",
+		installTimeout: time.Duration(0),
+	}
+
+	err := p.setup(ctx)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error %q, got error %q (of type %T)", context.Canceled, err, err)
+	}
+
+	if mockPusher.pushCalled {
+		t.Error("expected Pusher.Push() to not be called, but was")
+	}
+
+	records := mockStore.recorded()
+	if len(records) != 1 || records[0].Outcome != history.OutcomeCancelled {
+		t.Errorf("expected a single cancelled run to be recorded, got %+v", records)
+	}
+}
+
+func TestRunReturnsUponDoneWhileWaiting(t *testing.T) {
+	mockChecker := newMockChecker(false)
+	pollPeriodDuration := time.Hour // Long enough that run() will be waiting when done is closed
+	done := make(chan struct{})
+	returned := make(chan struct{})
+
+	p := &pipeline{
+		logger:         discardLogger,
+		tagDeducer:     newMockTagDeducer("mocktag"),
+		builder:        newMockBuilder(),
+		pusher:         newMockPusher(),
+		installer:      newMockInstaller(),
+		checker:        mockChecker,
+		updater:        newMockUpdater(),
+		resolver:       newMockResolver(),
+		store:          newMockStore(),
+		srcDirPath:     "",
+		imageName:      "",
+		pollPeriod:     pollPeriodDuration,
+		installTimeout: time.Duration(0),
+	}
+
+	go func() {
+		p.run(context.Background(), done)
+		close(returned)
+	}()
+
+	close(done)
+
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected run() to return upon done being closed, but did not")
+	}
+
+	if mockChecker.checkCalled {
+		t.Error("expected Checker.Check() to not be called, but was")
+	}
+}
+
+func TestSetupFailsUponStageTimeout(t *testing.T) {
+	mockBuilder := newMockBlockingBuilder(make(chan struct{}))
+	mockPusher := newMockPusher()
+	mockStore := newMockStore()
+
+	p := &pipeline{
+		logger:     discardLogger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    mockBuilder,
+		pusher:     mockPusher,
+		installer:  newMockInstaller(),
+		inspector:  newMockInspector("", ""),
+		resolver:   newMockResolver(),
+		store:      mockStore,
+		timeouts: stageTimeouts{
+			build: time.Millisecond,
+		},
+	}
+
+	err := p.setup(context.Background())
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %q, got error %q (of type %T)", context.DeadlineExceeded, err, err)
+	}
+
+	if mockPusher.pushCalled {
+		t.Error("expected Pusher.Push() to not be called, but was")
+	}
+
+	// A stage timing out is a failure of the run, not a cancellation of it
+	records := mockStore.recorded()
+	if len(records) != 1 || records[0].Outcome != history.OutcomeFailed {
+		t.Errorf("expected a single failed run to be recorded, got %+v", records)
+	}
+}
+
+func TestSetupArchivesStageOutput(t *testing.T) {
+	mockOutput := "mock build output"
+	mockStore := newMockStore()
+	logDirPath := t.TempDir()
+
+	p := &pipeline{
+		logger:     discardLogger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    newMockCommandBuilder(mockOutput),
+		pusher:     newMockPusher(),
+		installer:  newMockInstaller(),
+		inspector:  newMockInspector("", ""),
+		resolver:   newMockResolver(),
+		store:      mockStore,
+		archiver:   logarchive.NewDirArchiver(logDirPath, 0, 0, discardLogger),
+	}
+
+	if err := p.setup(context.Background()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	records := mockStore.recorded()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 run to be recorded, got %d", len(records))
+	}
+
+	logPath := records[0].LogPath
+	if filepath.Dir(logPath) != logDirPath {
+		t.Fatalf("expected run log to be in %q, got %q", logDirPath, logPath)
+	}
+
+	content, err := os.ReadFile(filepath.Join(logPath, "build.log"))
+	if err != nil {
+		t.Fatalf("reading build log: %v", err)
+	}
+	if string(content) != mockOutput+"\n" {
+		t.Errorf("expected build log content %q, got %q", mockOutput+"\n", content)
+	}
+}
+
+func TestSetupRecordsMetrics(t *testing.T) {
+	mockError := errors.New("mock builder error")
+	mockResolver := newMockResolver()
+	mockRecorder := newMockRecorder()
+
+	p := &pipeline{
+		logger:     discardLogger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    newMockErroringBuilder(mockError),
+		pusher:     newMockPusher(),
+		installer:  newMockInstaller(),
+		inspector:  newMockInspector("", ""),
+		resolver:   mockResolver,
+		store:      newMockStore(),
+		metrics:    mockRecorder,
+	}
+
+	if err := p.setup(context.Background()); !errors.Is(err, mockError) {
+		t.Errorf("expected error %q, got error %q (of type %T)", mockError, err, err)
+	}
+
+	expectedStages := []string{"deduce", "build"}
+	if len(mockRecorder.stages) != len(expectedStages) {
+		t.Fatalf("expected stages %v to be recorded, got %v", expectedStages, mockRecorder.stages)
+	}
+	for i, stage := range mockRecorder.stages {
+		if stage != expectedStages[i] {
+			t.Errorf("expected stage %d to be %q, got %q", i, expectedStages[i], stage)
+		}
+	}
+
+	if len(mockRecorder.failedStages) != 1 || mockRecorder.failedStages[0] != "build" {
+		t.Errorf("expected only build stage failure to be recorded, got %v", mockRecorder.failedStages)
+	}
+
+	if mockRecorder.deployedCommit != "" {
+		t.Errorf("expected no deploy to be recorded, got %q", mockRecorder.deployedCommit)
+	}
+}
+
+func TestRunRecordsMetricsUponChange(t *testing.T) {
+	installed := make(chan struct{})
+	installAcked := make(chan struct{})
+	mockResolver := newMockResolver()
+	mockRecorder := newMockRecorder()
+	done := make(chan struct{})
+
+	p := &pipeline{
+		logger:     discardLogger,
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    newMockBuilder(),
+		pusher:     newMockPusher(),
+		installer:  newMockAsyncInstaller(installed, installAcked),
+		checker:    newMockChecker(true),
+		updater:    newMockUpdater(),
+		resolver:   mockResolver,
+		store:      newMockStore(),
+		metrics:    mockRecorder,
+		pollPeriod: time.Nanosecond,
+	}
+
+	go p.run(context.Background(), done)
+
+	// Wait for the new release to be "installed", so that the done channel is not closed too early
+	<-installed
+
+	// Stop the run() goroutine from running forever
+	close(done)
+
+	// Signal to mock installer it is OK to continue
+	close(installAcked)
+
+	mockRecorder.mu.Lock()
+	defer mockRecorder.mu.Unlock()
+
+	if mockRecorder.checks == 0 {
+		t.Error("expected check to be recorded, but was not")
+	}
+	if mockRecorder.changes == 0 {
+		t.Error("expected change to be recorded, but was not")
+	}
+	if len(mockRecorder.stages) < 3 {
+		t.Errorf("expected deduce, build and push stages to be recorded, got %v", mockRecorder.stages)
+	}
+}
+
+func TestSetupReportsReadyUponSuccess(t *testing.T) {
+	mockHealthReporter := newMockHealthReporter()
+
+	p := &pipeline{
+		logger:     discardLogger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    newMockBuilder(),
+		pusher:     newMockPusher(),
+		installer:  newMockInstaller(),
+		inspector:  newMockInspector("", ""),
+		resolver:   newMockResolver(),
+		store:      newMockStore(),
+		health:     mockHealthReporter,
+	}
+
+	if err := p.setup(context.Background()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockHealthReporter.ready {
+		t.Error("expected ready to be reported, but was not")
+	}
+}
+
+func TestSetupDoesNotReportReadyUponError(t *testing.T) {
+	mockHealthReporter := newMockHealthReporter()
+
+	p := &pipeline{
+		logger:   discardLogger,
+		obtainer: newMockObtainer(errors.New("mock obtainer error")),
+		store:    newMockStore(),
+		health:   mockHealthReporter,
+	}
+
+	if err := p.setup(context.Background()); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if mockHealthReporter.ready {
+		t.Error("expected ready to not be reported, but was")
+	}
+}
+
+func TestSetupReportsWaitingForStagesUntilTheirDeadline(t *testing.T) {
+	mockHealthReporter := newMockHealthReporter()
+
+	p := &pipeline{
+		logger:     discardLogger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    newMockBuilder(),
+		pusher:     newMockPusher(),
+		installer:  newMockInstaller(),
+		inspector:  newMockInspector("", ""),
+		resolver:   newMockResolver(),
+		store:      newMockStore(),
+		health:     mockHealthReporter,
+		timeouts:   stageTimeouts{build: time.Hour},
+	}
+
+	started := time.Now()
+	if err := p.setup(context.Background()); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Only the build stage has a deadline, the others may run indefinitely
+	var deadlines, indefinite int
+	for _, deadline := range mockHealthReporter.deadlines {
+		switch {
+		case deadline.IsZero():
+			indefinite++
+		case deadline.After(started.Add(59 * time.Minute)):
+			deadlines++
+		default:
+			t.Errorf("expected deadline of build stage, got %v", deadline)
+		}
+	}
+
+	if deadlines != 1 || indefinite == 0 {
+		t.Errorf("expected waiting to be reported for each stage, got deadlines %v", mockHealthReporter.deadlines)
+	}
+}
+
+func TestRunReportsBeatPerIteration(t *testing.T) {
+	callCount := 3
+	checkCountReached := make(chan struct{})
+	checkAcked := make(chan struct{})
+	mockChecker := newMockCountingAsyncChecker(callCount, false, nil, checkCountReached, checkAcked)
+	mockHealthReporter := newMockHealthReporter()
+	done := make(chan struct{})
+
+	p := &pipeline{
+		logger:     discardLogger,
+		checker:    mockChecker,
+		store:      newMockStore(),
+		health:     mockHealthReporter,
+		pollPeriod: time.Nanosecond,
+	}
+
+	go p.run(context.Background(), done)
+
+	// Wait for the check count to be reached, so that the done channel is not closed too early
+	<-checkCountReached
+
+	mockHealthReporter.mu.Lock()
+	beats := mockHealthReporter.beats
+	mockHealthReporter.mu.Unlock()
+
+	// Stop the run() goroutine from running forever
+	close(done)
+
+	// Signal to mock checker it is OK to continue
+	close(checkAcked)
+
+	// One beat upon starting, and one upon completing each iteration before the last
+	if beats != callCount {
+		t.Errorf("expected %d beats to be reported, got %d", callCount, beats)
+	}
+}
+
+func TestSetupLogsCarryRunCorrelation(t *testing.T) {
+	mockOutput := "mock build output"
+	mockResolver := newMockResolver()
+	mockStore := newMockStore()
+	output := new(bytes.Buffer)
+	logger, err := logging.New(output, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("creating logger: %v", err)
+	}
+	mockBuilder := newMockCommandBuilder(mockOutput)
+	mockBuilder.logger = logger
+
+	p := &pipeline{
+		logger:     logger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    mockBuilder,
+		pusher:     newMockPusher(),
+		installer:  newMockInstaller(),
+		inspector:  newMockInspector("", ""),
+		resolver:   mockResolver,
+		store:      mockStore,
+	}
+
+	if err := p.setup(context.Background()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	records := mockStore.recorded()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 run to be recorded, got %d", len(records))
+	}
+
+	// Find the line logged from the build command's output
+	var line map[string]any
+	for _, raw := range bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n")) {
+		entry := make(map[string]any)
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			t.Fatalf("expected JSON log line, got %q: %v", raw, err)
+		}
+
+		if entry["msg"] == mockOutput {
+			line = entry
+		}
+	}
+	if line == nil {
+		t.Fatalf("expected build output to be logged, got %q", output)
+	}
+
+	expected := map[string]string{
+		"run_id": records[0].ID,
+		"commit": mockResolver.revisionToReturn,
+		"stage":  "build",
+		"stream": "stdout",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("expected %q to be %q, got %v", key, value, line[key])
+		}
+	}
+}
+
+func TestUpdateRecordsHistoryRewrite(t *testing.T) {
+	for _, isAncestor := range []bool{true, false} {
+		p := &pipeline{
+			logger:   discardLogger,
+			updater:  newMockUpdater(),
+			resolver: newMockResolver(),
+			ancestry: newMockAncestryChecker(isAncestor),
+		}
+
+		record := history.NewRun(history.ReasonChange, "mockimage")
+		if err := p.update(context.Background(), record); err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		if record.PreviousCommit != "mockrevision" {
+			t.Errorf("expected previous commit %q, got %q", "mockrevision", record.PreviousCommit)
+		}
+
+		if record.HistoryRewritten == isAncestor {
+			t.Errorf("expected history rewritten to be %t when previous commit is ancestor: %t",
+				!isAncestor, isAncestor)
+		}
+	}
+}
+
+func TestRunSkipsBuildAndInstallUponIrrelevantChange(t *testing.T) {
+	mockBuilder := newMockBuilder()
+	mockInstaller := newMockInstaller()
+	mockStore := newMockStore()
+	mockStore.records = []history.Run{{Commit: "mockdeployed", Outcome: history.OutcomeSucceeded}}
+	updated := make(chan struct{})
+	updateAcked := make(chan struct{})
+	done := make(chan struct{})
+
+	p := &pipeline{
+		logger:     discardLogger,
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    mockBuilder,
+		pusher:     newMockPusher(),
+		installer:  mockInstaller,
+		checker:    newMockChecker(true),
+		updater:    newMockCountingAsyncUpdater(2, nil, updated, updateAcked),
+		resolver:   newMockResolver(),
+		filter:     newMockFilter(false),
+		store:      mockStore,
+		pollPeriod: time.Nanosecond,
+		imageName:  "mockimage",
+	}
+
+	go p.run(context.Background(), done)
+
+	// The second update means the first change has been fully handled
+	<-updated
+	close(done)
+	close(updateAcked)
+
+	if mockBuilder.buildCalled {
+		t.Error("expected Builder.Build() not to be called, but was")
+	}
+	if mockInstaller.installCalled {
+		t.Error("expected Installer.Install() not to be called, but was")
+	}
+
+	records := mockStore.recorded()
+	if len(records) < 2 {
+		t.Fatal("expected skipped run to be recorded, but none was")
+	}
+
+	if records[1].Outcome != history.OutcomeSkipped || records[1].Commit != "mockrevision" {
+		t.Errorf("expected skipped run of commit %q, got %q run of commit %q",
+			"mockrevision", records[1].Outcome, records[1].Commit)
+	}
+}
+
+func TestRunFiltersChangeFromLastSkippedCommit(t *testing.T) {
+	mockBuilder := newMockBuilder()
+	mockStore := newMockStore()
+	mockStore.records = []history.Run{
+		{Commit: "mockdeployed", Tag: "mockdeployedtag", Outcome: history.OutcomeSucceeded},
+		{Commit: "mockskipped", Tag: "mockdeployedtag", Outcome: history.OutcomeSkipped},
+	}
+	mockFilter := newMockFilter(false)
+	updated := make(chan struct{})
+	updateAcked := make(chan struct{})
+	done := make(chan struct{})
+
+	p := &pipeline{
+		logger:     discardLogger,
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    mockBuilder,
+		pusher:     newMockPusher(),
+		installer:  newMockInstaller(),
+		checker:    newMockChecker(true),
+		updater:    newMockCountingAsyncUpdater(2, nil, updated, updateAcked),
+		resolver:   newMockResolver(),
+		filter:     mockFilter,
+		store:      mockStore,
+		pollPeriod: time.Nanosecond,
+		imageName:  "mockimage",
+	}
+
+	go p.run(context.Background(), done)
+
+	// The second update means the first change has been fully handled
+	<-updated
+	close(done)
+	close(updateAcked)
+
+	if from := mockFilter.filteredFrom(); len(from) == 0 || from[0] != "mockskipped" {
+		t.Errorf("expected change to be filtered from commit %q, got %q", "mockskipped", from)
+	}
+	if mockBuilder.buildCalled {
+		t.Error("expected Builder.Build() not to be called, but was")
+	}
+
+	// The image of the commit deployed remains installed
+	records := mockStore.recorded()
+	if len(records) < 3 || records[2].Outcome != history.OutcomeSkipped || records[2].Tag != "mockdeployedtag" {
+		t.Errorf("expected skipped run with tag %q to be recorded, got %+v", "mockdeployedtag", records)
+	}
+}
+
+func TestRunBuildsAndInstallsIrrelevantChangeAfterFailedChange(t *testing.T) {
+	mockBuilder := newMockBuilder()
+	mockInstaller := newMockInstaller()
+	mockStore := newMockStore()
+	// The relevant change of the failed commit has not been deployed
+	mockStore.records = []history.Run{
+		{Commit: "mockdeployed", Outcome: history.OutcomeSucceeded},
+		{Commit: "mockfailed", Outcome: history.OutcomeFailed},
+	}
+	// The change since the failed commit is irrelevant, but the change since the commit
+	// deployed includes that of the failed commit, so is relevant
+	mockFilter := newMockFilter(false)
+	mockFilter.relevantFrom = map[string]bool{"mockdeployed": true}
+	updated := make(chan struct{})
+	updateAcked := make(chan struct{})
+	done := make(chan struct{})
+
+	p := &pipeline{
+		logger:     discardLogger,
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    mockBuilder,
+		pusher:     newMockPusher(),
+		installer:  mockInstaller,
+		checker:    newMockChecker(true),
+		updater:    newMockCountingAsyncUpdater(2, nil, updated, updateAcked),
+		resolver:   &mockResolver{revisionToReturn: "mockfailed"},
+		filter:     mockFilter,
+		store:      mockStore,
+		pollPeriod: time.Nanosecond,
+		imageName:  "mockimage",
+	}
+
+	go p.run(context.Background(), done)
+
+	// The second update means the first change has been fully handled
+	<-updated
+	close(done)
+	close(updateAcked)
+
+	if from := mockFilter.filteredFrom(); len(from) == 0 || from[0] != "mockdeployed" {
+		t.Errorf("expected change to be filtered from commit %q, got %q", "mockdeployed", from)
+	}
+	if !mockBuilder.buildCalled {
+		t.Error("expected Builder.Build() to be called, but was not")
+	}
+	if !mockInstaller.installCalled {
+		t.Error("expected Installer.Install() to be called, but was not")
+	}
+}
+
+func TestSetupPrunesAfterBuildWhateverTheOutcome(t *testing.T) {
+	mockError := errors.New("mock builder error")
+	tests := []struct {
+		name          string
+		builder       build.Builder
+		expectedError error
+	}{
+		{"success", newMockBuilder(), nil},
+		{"build error", newMockErroringBuilder(mockError), mockError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// An error pruning does not fail the run
+			mockPruner := newMockPruner(errors.New("mock pruner error"))
+
+			p := &pipeline{
+				logger:     discardLogger,
+				obtainer:   newMockObtainer(nil),
+				tagDeducer: newMockTagDeducer("mocktag"),
+				builder:    test.builder,
+				pusher:     newMockPusher(),
+				pruner:     mockPruner,
+				installer:  newMockInstaller(),
+				inspector:  newMockInspector("", ""),
+				resolver:   newMockResolver(),
+				store:      newMockStore(),
+			}
+
+			if err := p.setup(context.Background()); !errors.Is(err, test.expectedError) {
+				t.Errorf("expected error %v, got %v", test.expectedError, err)
+			}
+
+			if mockPruner.callCount != 1 {
+				t.Errorf("expected Pruner.Prune() to be called once, was called %d times", mockPruner.callCount)
+			}
+		})
+	}
+}
+
+func TestSetupSkipsBuildAndPushWhenImageExists(t *testing.T) {
+	mockImageChecker := newMockImageChecker(true, nil)
+	mockBuilder := newMockBuilder()
+	mockPusher := newMockPusher()
+	mockPruner := newMockPruner(nil)
+	mockInstaller := newMockInstaller()
+	mockStore := newMockStore()
+
+	p := &pipeline{
+		logger:     discardLogger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    mockBuilder,
+		pusher:     mockPusher,
+		pruner:     mockPruner,
+		imageCheck: mockImageChecker,
+		installer:  mockInstaller,
+		inspector:  newMockInspector("", ""),
+		resolver:   newMockResolver(),
+		store:      mockStore,
+	}
+
+	if err := p.setup(context.Background()); err != nil {
+		t.Fatalf("expected nil error, got %q", err)
+	}
+
+	if mockImageChecker.checkedTag != "mocktag" {
+		t.Errorf("expected image of tag %q to be checked, got %q", "mocktag", mockImageChecker.checkedTag)
+	}
+
+	if mockBuilder.buildCalled {
+		t.Error("expected Builder.Build() to not be called, but was")
+	}
+
+	if mockPusher.pushCalled {
+		t.Error("expected Pusher.Push() to not be called, but was")
+	}
+
+	if mockPruner.callCount != 0 {
+		t.Errorf("expected Pruner.Prune() to not be called, was called %d times", mockPruner.callCount)
+	}
+
+	if !mockInstaller.installCalled {
+		t.Error("expected Installer.Install() to be called, but was not")
+	}
+
+	records := mockStore.recorded()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 run to be recorded, got %d", len(records))
+	}
+
+	record := records[0]
+	if record.Outcome != history.OutcomeSucceeded || !record.ImageExisted {
+		t.Errorf("expected succeeded run with existing image to be recorded, got %+v", record)
+	}
+}
+
+func TestSetupBuildsAndPushesUnlessImageFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		checker *mockImageChecker
+	}{
+		{"not found", newMockImageChecker(false, nil)},
+		{"error", newMockImageChecker(true, errors.New("mock image checker error"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockBuilder := newMockBuilder()
+			mockPusher := newMockPusher()
+			mockStore := newMockStore()
+
+			p := &pipeline{
+				logger:     discardLogger,
+				obtainer:   newMockObtainer(nil),
+				tagDeducer: newMockTagDeducer("mocktag"),
+				builder:    mockBuilder,
+				pusher:     mockPusher,
+				imageCheck: test.checker,
+				installer:  newMockInstaller(),
+				inspector:  newMockInspector("", ""),
+				resolver:   newMockResolver(),
+				store:      mockStore,
+			}
+
+			if err := p.setup(context.Background()); err != nil {
+				t.Fatalf("expected nil error, got %q", err)
+			}
+
+			if !mockBuilder.buildCalled {
+				t.Error("expected Builder.Build() to be called, but was not")
+			}
+
+			if !mockPusher.pushCalled {
+				t.Error("expected Pusher.Push() to be called, but was not")
+			}
+
+			if records := mockStore.recorded(); len(records) != 1 || records[0].ImageExisted {
+				t.Errorf("expected 1 run without existing image to be recorded, got %+v", records)
+			}
+		})
+	}
+}
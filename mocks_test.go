@@ -1,6 +1,19 @@
 package main
 
-import "time"
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/history"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
 
 type mockObtainer struct {
 	errorToReturn error
@@ -12,7 +25,7 @@ func newMockObtainer(errorToReturn error) *mockObtainer {
 	return &mockObtainer{errorToReturn: errorToReturn}
 }
 
-func (mo *mockObtainer) Obtain(destPath string) error {
+func (mo *mockObtainer) Obtain(ctx context.Context, destPath string) error {
 	mo.obtainCalled = true
 
 	if mo.errorToReturn != nil {
@@ -33,7 +46,7 @@ func newMockTagDeducer(tagToReturn string) *mockTagDeducer {
 	return &mockTagDeducer{tagToReturn: tagToReturn}
 }
 
-func (md *mockTagDeducer) Deduce() (string, error) {
+func (md *mockTagDeducer) Deduce(ctx context.Context) (string, error) {
 	md.deduceCalled = true
 	md.callCount++
 
@@ -65,7 +78,7 @@ func newMockCountingAsyncTagDeducer(closeAfterCallCount int,
 	}
 }
 
-func (md *mockCountingAsyncTagDeducer) Deduce() (string, error) {
+func (md *mockCountingAsyncTagDeducer) Deduce(ctx context.Context) (string, error) {
 	md.deduceCalled = true
 	md.callCount++
 
@@ -98,7 +111,7 @@ func newMockBuilder() *mockBuilder {
 	return new(mockBuilder)
 }
 
-func (mb *mockBuilder) Build(buildContextPath, name, tag string) error {
+func (mb *mockBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
 	mb.buildCalled = true
 	mb.callCount++
 
@@ -127,7 +140,7 @@ func newMockCountingAsyncBuilder(closeAfterCallCount int,
 	}
 }
 
-func (mb *mockCountingAsyncBuilder) Build(buildContextPath, name, tag string) error {
+func (mb *mockCountingAsyncBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
 	mb.buildCalled = true
 	mb.callCount++
 
@@ -160,7 +173,7 @@ func newMockPusher() *mockPusher {
 	return new(mockPusher)
 }
 
-func (mp *mockPusher) Push(name, tag string) error {
+func (mp *mockPusher) Push(ctx context.Context, name, tag string) error {
 	mp.pushCalled = true
 	mp.callCount++
 
@@ -189,7 +202,7 @@ func newMockCountingAsyncPusher(closeAfterCallCount int,
 	}
 }
 
-func (mp *mockCountingAsyncPusher) Push(name, tag string) error {
+func (mp *mockCountingAsyncPusher) Push(ctx context.Context, name, tag string) error {
 	mp.pushCalled = true
 	mp.callCount++
 
@@ -222,7 +235,7 @@ func newMockInstaller() *mockInstaller {
 	return new(mockInstaller)
 }
 
-func (mi *mockInstaller) Install(imageName, imageTag string, timeout time.Duration) error {
+func (mi *mockInstaller) Install(ctx context.Context, imageName, imageTag string, timeout time.Duration) error {
 	mi.installCalled = true
 	mi.callCount++
 
@@ -243,7 +256,7 @@ func newMockAsyncInstaller(installed chan<- struct{}, installAcked <-chan struct
 	}
 }
 
-func (mi *mockAsyncInstaller) Install(imageName, imageTag string, timeout time.Duration) error {
+func (mi *mockAsyncInstaller) Install(ctx context.Context, imageName, imageTag string, timeout time.Duration) error {
 	mi.installCalled = true
 
 	// As installing is the last step of the run() function, we use Install() having been called
@@ -281,7 +294,7 @@ func newMockCountingAsyncInstaller(closeAfterCallCount int,
 	}
 }
 
-func (mi *mockCountingAsyncInstaller) Install(imageName, imageTag string, timeout time.Duration) error {
+func (mi *mockCountingAsyncInstaller) Install(ctx context.Context, imageName, imageTag string, timeout time.Duration) error {
 	mi.installCalled = true
 	mi.callCount++
 
@@ -315,7 +328,7 @@ func newMockChecker(newVersionAvailable bool) *mockChecker {
 	return &mockChecker{newVersionAvailable: newVersionAvailable}
 }
 
-func (mc *mockChecker) Check() (bool, error) {
+func (mc *mockChecker) Check(ctx context.Context) (bool, error) {
 	mc.checkCalled = true
 	mc.callCount++
 
@@ -347,7 +360,7 @@ func newMockCountingAsyncChecker(closeAfterCallCount int,
 	}
 }
 
-func (mc *mockCountingAsyncChecker) Check() (bool, error) {
+func (mc *mockCountingAsyncChecker) Check(ctx context.Context) (bool, error) {
 	mc.checkCalled = true
 	mc.callCount++
 
@@ -390,7 +403,7 @@ func newMockAsyncChecker(newVersionAvailable bool,
 	}
 }
 
-func (mc *mockAsyncChecker) Check() (bool, error) {
+func (mc *mockAsyncChecker) Check(ctx context.Context) (bool, error) {
 	mc.checkCalled = true
 
 	// As checking is the last step of the run() function in the case where there is no change,
@@ -416,7 +429,7 @@ func newMockUpdater() *mockUpdater {
 	return new(mockUpdater)
 }
 
-func (mu *mockUpdater) Update(path string) error {
+func (mu *mockUpdater) Update(ctx context.Context, path string) error {
 	mu.updateCalled = true
 	mu.callCount++
 
@@ -445,7 +458,7 @@ func newMockCountingAsyncUpdater(closeAfterCallCount int,
 	}
 }
 
-func (mu *mockCountingAsyncUpdater) Update(path string) error {
+func (mu *mockCountingAsyncUpdater) Update(ctx context.Context, path string) error {
 	mu.updateCalled = true
 	mu.callCount++
 
@@ -468,3 +481,358 @@ func (mu *mockCountingAsyncUpdater) Update(path string) error {
 
 	return nil
 }
+
+type mockTriggerer struct {
+	triggered chan struct{}
+}
+
+func newMockTriggerer() *mockTriggerer {
+	return &mockTriggerer{triggered: make(chan struct{}, 1)}
+}
+
+func (mt *mockTriggerer) Triggered() <-chan struct{} {
+	return mt.triggered
+}
+
+func (mt *mockTriggerer) trigger() {
+	mt.triggered <- struct{}{}
+}
+
+type mockResolver struct {
+	revisionToReturn string
+}
+
+func newMockResolver() *mockResolver {
+	return &mockResolver{revisionToReturn: "mockrevision"}
+}
+
+func (mr *mockResolver) Resolve(ctx context.Context) (string, error) {
+	return mr.revisionToReturn, nil
+}
+
+type mockStore struct {
+	mu      sync.Mutex
+	records []history.Run
+}
+
+func newMockStore() *mockStore {
+	return new(mockStore)
+}
+
+func (ms *mockStore) Record(run *history.Run) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.records = append(ms.records, *run)
+	return nil
+}
+
+func (ms *mockStore) LastSucceeded() (*history.Run, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := len(ms.records) - 1; i >= 0; i-- {
+		if ms.records[i].Outcome == history.OutcomeSucceeded {
+			run := ms.records[i]
+			return &run, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (ms *mockStore) LastProcessed() (*history.Run, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := len(ms.records) - 1; i >= 0; i-- {
+		if ms.records[i].Outcome == history.OutcomeSucceeded || ms.records[i].Outcome == history.OutcomeSkipped {
+			run := ms.records[i]
+			return &run, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (ms *mockStore) recorded() []history.Run {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return append([]history.Run(nil), ms.records...)
+}
+
+type mockErroringBuilder struct {
+	errorToReturn error
+
+	buildCalled bool
+}
+
+func newMockErroringBuilder(errorToReturn error) *mockErroringBuilder {
+	return &mockErroringBuilder{errorToReturn: errorToReturn}
+}
+
+func (mb *mockErroringBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
+	mb.buildCalled = true
+
+	return mb.errorToReturn
+}
+
+type mockInspector struct {
+	imageNameToReturn string
+	imageTagToReturn  string
+	errorToReturn     error
+
+	installedCalled bool
+}
+
+func newMockInspector(imageNameToReturn, imageTagToReturn string) *mockInspector {
+	return &mockInspector{
+		imageNameToReturn: imageNameToReturn,
+		imageTagToReturn:  imageTagToReturn,
+	}
+}
+
+func (mi *mockInspector) Installed(ctx context.Context) (string, string, error) {
+	mi.installedCalled = true
+
+	if mi.errorToReturn != nil {
+		return "", "", mi.errorToReturn
+	}
+
+	return mi.imageNameToReturn, mi.imageTagToReturn, nil
+}
+
+type mockBlockingBuilder struct {
+	building chan<- struct{}
+
+	buildCalled bool
+}
+
+func newMockBlockingBuilder(building chan<- struct{}) *mockBlockingBuilder {
+	return &mockBlockingBuilder{building: building}
+}
+
+func (mb *mockBlockingBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
+	mb.buildCalled = true
+
+	// Simulate a long-running build which only ends when cancelled
+	close(mb.building)
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+type mockCommandBuilder struct {
+	output string
+	logger *slog.Logger
+}
+
+func newMockCommandBuilder(output string) *mockCommandBuilder {
+	return &mockCommandBuilder{
+		output: output,
+		logger: discardLogger,
+	}
+}
+
+func (mb *mockCommandBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
+	// Run a real command, so that its output is handled as a real builder's would be
+	return executil.Run(ctx, mb.logger, exec.CommandContext(ctx, "echo", mb.output))
+}
+
+type mockRecorder struct {
+	mu              sync.Mutex
+	checks          int
+	changes         int
+	stages          []string
+	failedStages    []string
+	installedCommit string
+	deployedCommit  string
+}
+
+func newMockRecorder() *mockRecorder {
+	return new(mockRecorder)
+}
+
+func (mr *mockRecorder) Checked(changed bool, err error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.checks++
+	if changed {
+		mr.changes++
+	}
+}
+
+func (mr *mockRecorder) Staged(stage string, duration time.Duration, err error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.stages = append(mr.stages, stage)
+	if err != nil {
+		mr.failedStages = append(mr.failedStages, stage)
+	}
+}
+
+func (mr *mockRecorder) Installed(commit string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.installedCommit = commit
+}
+
+func (mr *mockRecorder) Deployed(commit string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.installedCommit = commit
+	mr.deployedCommit = commit
+}
+
+type mockHealthReporter struct {
+	mu        sync.Mutex
+	ready     bool
+	beats     int
+	deadlines []time.Time
+}
+
+func newMockHealthReporter() *mockHealthReporter {
+	return new(mockHealthReporter)
+}
+
+func (mh *mockHealthReporter) Ready() {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	mh.ready = true
+}
+
+func (mh *mockHealthReporter) Beat() {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	mh.beats++
+}
+
+func (mh *mockHealthReporter) Wait(deadline time.Time) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	mh.deadlines = append(mh.deadlines, deadline)
+}
+
+type mockAncestryChecker struct {
+	isAncestor bool
+}
+
+func newMockAncestryChecker(isAncestor bool) *mockAncestryChecker {
+	return &mockAncestryChecker{isAncestor: isAncestor}
+}
+
+func (ma *mockAncestryChecker) IsAncestor(ctx context.Context, ancestor, descendant string) (bool, error) {
+	return ma.isAncestor, nil
+}
+
+type mockLister struct {
+	refsToReturn []gitutil.Refs
+
+	callCount int
+}
+
+// newMockLister returns a lister which returns each of the listings in turn, repeating
+// the last once all have been returned.
+func newMockLister(refsToReturn ...gitutil.Refs) *mockLister {
+	return &mockLister{refsToReturn: refsToReturn}
+}
+
+func (ml *mockLister) List(ctx context.Context) (gitutil.Refs, error) {
+	refs := ml.refsToReturn[min(ml.callCount, len(ml.refsToReturn)-1)]
+	ml.callCount++
+
+	return refs, nil
+}
+
+type mockUninstaller struct {
+	mu              sync.Mutex
+	uninstallCalled bool
+}
+
+func newMockUninstaller() *mockUninstaller {
+	return new(mockUninstaller)
+}
+
+func (mu *mockUninstaller) Uninstall(ctx context.Context) error {
+	mu.mu.Lock()
+	defer mu.mu.Unlock()
+
+	mu.uninstallCalled = true
+	return nil
+}
+
+func (mu *mockUninstaller) called() bool {
+	mu.mu.Lock()
+	defer mu.mu.Unlock()
+
+	return mu.uninstallCalled
+}
+
+type mockFilter struct {
+	relevant bool
+	// relevantFrom holds the commits the change from which is relevant regardless
+	relevantFrom map[string]bool
+
+	mu   sync.Mutex
+	from []string
+}
+
+func newMockFilter(relevant bool) *mockFilter {
+	return &mockFilter{relevant: relevant}
+}
+
+func (mf *mockFilter) Relevant(ctx context.Context, from, to string) (bool, error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	mf.from = append(mf.from, from)
+	return mf.relevant || mf.relevantFrom[from], nil
+}
+
+func (mf *mockFilter) filteredFrom() []string {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	return append([]string(nil), mf.from...)
+}
+
+type mockPruner struct {
+	errorToReturn error
+
+	callCount int
+}
+
+func newMockPruner(errorToReturn error) *mockPruner {
+	return &mockPruner{errorToReturn: errorToReturn}
+}
+
+func (mp *mockPruner) Prune(ctx context.Context) error {
+	mp.callCount++
+
+	return mp.errorToReturn
+}
+
+type mockImageChecker struct {
+	exists        bool
+	errorToReturn error
+
+	checkedTag string
+}
+
+func newMockImageChecker(exists bool, errorToReturn error) *mockImageChecker {
+	return &mockImageChecker{exists: exists, errorToReturn: errorToReturn}
+}
+
+func (mc *mockImageChecker) Exists(ctx context.Context, name, tag string) (bool, error) {
+	mc.checkedTag = tag
+
+	return mc.exists, mc.errorToReturn
+}
@@ -1,44 +1,130 @@
 package build
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os/exec"
+	"log/slog"
+	"strings"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
+)
+
+const (
+	KindDocker   = "docker"
+	KindPodman   = "podman"
+	KindBuildah  = "buildah"
+	KindBuildKit = "buildkit"
+	KindOCI      = "oci"
 )
 
 type Builder interface {
-	Build(buildContextPath, name, tag string) error
+	Build(ctx context.Context, buildContextPath, name, tag, commit string) error
 }
 
-type DockerCLIBuilder struct{}
+type DockerCLIBuilder struct {
+	Options Options
+	Logger  *slog.Logger
+}
 
-func NewDockerCLIBuilder() *DockerCLIBuilder {
-	return new(DockerCLIBuilder)
+func NewDockerCLIBuilder(options Options, logger *slog.Logger) *DockerCLIBuilder {
+	return &DockerCLIBuilder{
+		Options: options,
+		Logger:  logger,
+	}
 }
 
-func (*DockerCLIBuilder) Build(buildContextPath, name, tag string) error {
+func (b *DockerCLIBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
 	/*
 		docker build \
 		-f docker/Dockerfile \
 		-t "${image_name}:${image_tag}" \
+		--target "$target" \
+		--platform "$platforms" \
+		--build-arg "$name=$value" \
+		--label "$name=$value" \
+		--cache-from "type=registry,ref=$ref" \
+		--cache-to "type=registry,ref=$ref,mode=max" \
 		"$src_dir"
 	*/
 
 	fullImageName := name + ":" + tag
-	log.Printf("building docker image %q", fullImageName)
+	b.Logger.InfoContext(ctx, "building docker image", "image", fullImageName)
+
+	args, err := b.args(buildContextPath, newRevision(name, tag, commit))
+	if err != nil {
+		return fmt.Errorf("preparing docker build command: %w", err)
+	}
+
+	if err := runBuildCommand(ctx, b.Logger, "docker", args, buildContextPath); err != nil {
+		return err
+	}
+	b.Logger.InfoContext(ctx, "image built", "image", fullImageName)
+
+	return nil
+}
+
+func (b *DockerCLIBuilder) args(buildContextPath string, revision Revision) ([]string, error) {
+	args, err := dockerfileBuildArgs(b.Options, buildContextPath, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cache := range b.Options.CacheFrom {
+		args = append(args, "--cache-from", cache.importSpec())
+	}
+
+	for _, cache := range b.Options.CacheTo {
+		args = append(args, "--cache-to", cache.exportSpec())
+	}
+
+	return append(args, buildContextPath), nil
+}
 
-	cmd := exec.Command("docker",
+// dockerfileBuildArgs returns the arguments of "docker build", other than those of the
+// cache and the build context, which are also accepted by the build commands of the CLIs
+// compatible with docker.
+func dockerfileBuildArgs(options Options, buildContextPath string, revision Revision) ([]string, error) {
+	args := []string{
 		"build",
-		"-f", "docker/Dockerfile",
-		"-t", fullImageName,
-		buildContextPath)
+		"-f", options.Dockerfile(buildContextPath),
+		"-t", revision.Image + ":" + revision.Tag,
+	}
+
+	if options.Target != "" {
+		args = append(args, "--target", options.Target)
+	}
+
+	if len(options.Platforms) > 0 {
+		args = append(args, "--platform", strings.Join(options.Platforms, ","))
+	}
+
+	buildArgs, err := renderVariables(options.BuildArgs, revision)
+	if err != nil {
+		return nil, fmt.Errorf("rendering build args: %w", err)
+	}
+	for _, buildArg := range buildArgs {
+		args = append(args, "--build-arg", buildArg)
+	}
+
+	labels, err := renderVariables(options.Labels, revision)
+	if err != nil {
+		return nil, fmt.Errorf("rendering labels: %w", err)
+	}
+	for _, label := range labels {
+		args = append(args, "--label", label)
+	}
+
+	return args, nil
+}
+
+// runBuildCommand runs the build command of the CLI, logging its output.
+func runBuildCommand(ctx context.Context, logger *slog.Logger, name string, args []string, buildContextPath string) error {
+	cmd := executil.Command(ctx, name, args...)
 
-	log.Printf("executing command: %s", cmd.String())
-	if err := cmd.Run(); err != nil {
-		// TODO: Capture stderr and use in error
-		return fmt.Errorf("running docker build command with context %q: %w", buildContextPath, err)
+	logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	if err := executil.Run(ctx, logger, cmd); err != nil {
+		return fmt.Errorf("running %s build command with context %q: %w", name, buildContextPath, err)
 	}
-	log.Println("image built")
 
 	return nil
 }
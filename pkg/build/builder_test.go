@@ -0,0 +1,194 @@
+package build
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestDockerCLIBuilderArgs(t *testing.T) {
+	buildArgs, err := ParseVariables([]string{"COMMIT={{.ShortCommit}}", "BUILT_AT={{.BuildTimestamp}}"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	labels, err := ParseVariables([]string{"org.opencontainers.image.revision={{.Commit}}"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	b := NewDockerCLIBuilder(Options{
+		DockerfilePath:      "deploy/Dockerfile",
+		DockerfileInContext: true,
+		BuildArgs:           buildArgs,
+		Target:              "runtime",
+		Labels:              labels,
+		Platforms:           []string{"linux/amd64"},
+	}, discardLogger)
+
+	args, err := b.args("src", Revision{
+		Image:     "registry.example.com/app",
+		Tag:       "v1.0.0",
+		Commit:    "0123456789abcdef0123456789abcdef01234567",
+		BuildTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := []string{
+		"build",
+		"-f", "src/deploy/Dockerfile",
+		"-t", "registry.example.com/app:v1.0.0",
+		"--target", "runtime",
+		"--platform", "linux/amd64",
+		"--build-arg", "COMMIT=0123456",
+		"--build-arg", "BUILT_AT=2024-01-02T03:04:05Z",
+		"--label", "org.opencontainers.image.revision=0123456789abcdef0123456789abcdef01234567",
+		"src",
+	}
+	if !slices.Equal(args, expected) {
+		t.Errorf("expected args %q, got %q", expected, args)
+	}
+}
+
+func TestDockerCLIBuilderArgsDefaultDockerfile(t *testing.T) {
+	b := NewDockerCLIBuilder(Options{}, discardLogger)
+
+	args, err := b.args("src", Revision{Image: "app", Tag: "abc"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := []string{"build", "-f", "docker/Dockerfile", "-t", "app:abc", "src"}
+	if !slices.Equal(args, expected) {
+		t.Errorf("expected args %q, got %q", expected, args)
+	}
+}
+
+func TestCLIBuildersRunCommand(t *testing.T) {
+	buildArgs, err := ParseVariables([]string{"COMMIT={{.ShortCommit}}"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	labels, err := ParseVariables([]string{"org.opencontainers.image.revision={{.Commit}}"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	options := Options{
+		DockerfilePath:      "deploy/Dockerfile",
+		DockerfileInContext: true,
+		BuildArgs:           buildArgs,
+		Target:              "runtime",
+		Labels:              labels,
+		Platforms:           []string{"linux/arm64"},
+	}
+
+	dockerfileArgs := []string{
+		"build",
+		"-f", "src/deploy/Dockerfile",
+		"-t", "registry.example.com/app:v1.0.0",
+		"--target", "runtime",
+		"--platform", "linux/arm64",
+		"--build-arg", "COMMIT=0123456",
+		"--label", "org.opencontainers.image.revision=0123456789abcdef0123456789abcdef01234567",
+		"src",
+	}
+
+	tests := []struct {
+		command  string
+		builder  Builder
+		expected []string
+	}{
+		{"docker", NewDockerCLIBuilder(options, discardLogger), dockerfileArgs},
+		{"podman", NewCLIBuilder("podman", options, discardLogger), dockerfileArgs},
+		{"buildah", NewCLIBuilder("buildah", options, discardLogger), dockerfileArgs},
+		{"buildctl", NewBuildKitCLIBuilder(options, discardLogger), []string{
+			"build",
+			"--frontend", "dockerfile.v0",
+			"--local", "context=src",
+			"--local", "dockerfile=src/deploy",
+			"--opt", "filename=Dockerfile",
+			"--opt", "target=runtime",
+			"--opt", "platform=linux/arm64",
+			"--opt", "build-arg:COMMIT=0123456",
+			"--opt", "label:org.opencontainers.image.revision=0123456789abcdef0123456789abcdef01234567",
+			"--output", "type=image,name=registry.example.com/app:v1.0.0,push=true",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			argsPath := fakeExecutable(t, test.command, 0)
+
+			err := test.builder.Build(context.Background(),
+				"src",
+				"registry.example.com/app",
+				"v1.0.0",
+				"0123456789abcdef0123456789abcdef01234567")
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			if args := readArgs(t, argsPath); !slices.Equal(args, test.expected) {
+				t.Errorf("expected args %q, got %q", test.expected, args)
+			}
+		})
+	}
+}
+
+func TestCLIBuilderCommandFails(t *testing.T) {
+	fakeExecutable(t, "podman", 1)
+
+	b := NewCLIBuilder("podman", Options{DockerfilePath: "docker/Dockerfile"}, discardLogger)
+	if err := b.Build(context.Background(), "src", "app", "abc", "abc"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+// fakeExecutable puts an executable of the name first on the PATH, which records its
+// arguments, one per line, in the returned file and exits with the status.
+func fakeExecutable(t *testing.T, name string, status int) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, name+".args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > '" + argsPath + "'\nexit " + strconv.Itoa(status) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return argsPath
+}
+
+func readArgs(t *testing.T, argsPath string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestParseVariablesRejectsMalformed(t *testing.T) {
+	for _, pair := range []string{"NOVALUE", "=value", "BAD={{.Commit"} {
+		if _, err := ParseVariables([]string{pair}); err == nil {
+			t.Errorf("expected error for %q, got nil", pair)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// BuildKitCLIBuilder builds images by using the external buildctl CLI binary of BuildKit,
+// which connects to the buildkitd daemon at the address in the BUILDKIT_HOST environment
+// variable, or the default if not set. As buildkitd has no image store of its own to push
+// from, the image is pushed to the registry as part of the build.
+type BuildKitCLIBuilder struct {
+	Options Options
+	Logger  *slog.Logger
+}
+
+func NewBuildKitCLIBuilder(options Options, logger *slog.Logger) *BuildKitCLIBuilder {
+	return &BuildKitCLIBuilder{
+		Options: options,
+		Logger:  logger,
+	}
+}
+
+func (b *BuildKitCLIBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
+	/*
+		buildctl build \
+		--frontend dockerfile.v0 \
+		--local context="$src_dir" \
+		--local dockerfile="$(dirname docker/Dockerfile)" \
+		--opt filename="$(basename docker/Dockerfile)" \
+		--opt target="$target" \
+		--opt platform="$platforms" \
+		--opt build-arg:"$name=$value" \
+		--opt label:"$name=$value" \
+		--import-cache "type=registry,ref=$ref" \
+		--export-cache "type=registry,ref=$ref,mode=max" \
+		--output type=image,name="${image_name}:${image_tag}",push=true
+	*/
+
+	fullImageName := name + ":" + tag
+	b.Logger.InfoContext(ctx, "building and pushing buildkit image", "image", fullImageName)
+
+	args, err := b.args(buildContextPath, newRevision(name, tag, commit))
+	if err != nil {
+		return fmt.Errorf("preparing buildctl build command: %w", err)
+	}
+
+	if err := runBuildCommand(ctx, b.Logger, "buildctl", args, buildContextPath); err != nil {
+		return err
+	}
+	b.Logger.InfoContext(ctx, "image built and pushed", "image", fullImageName)
+
+	return nil
+}
+
+func (b *BuildKitCLIBuilder) args(buildContextPath string, revision Revision) ([]string, error) {
+	dockerfile := b.Options.Dockerfile(buildContextPath)
+	args := []string{
+		"build",
+		"--frontend", "dockerfile.v0",
+		"--local", "context=" + buildContextPath,
+		"--local", "dockerfile=" + filepath.Dir(dockerfile),
+		"--opt", "filename=" + filepath.Base(dockerfile),
+	}
+
+	if b.Options.Target != "" {
+		args = append(args, "--opt", "target="+b.Options.Target)
+	}
+
+	if len(b.Options.Platforms) > 0 {
+		args = append(args, "--opt", "platform="+strings.Join(b.Options.Platforms, ","))
+	}
+
+	buildArgs, err := renderVariables(b.Options.BuildArgs, revision)
+	if err != nil {
+		return nil, fmt.Errorf("rendering build args: %w", err)
+	}
+	for _, buildArg := range buildArgs {
+		args = append(args, "--opt", "build-arg:"+buildArg)
+	}
+
+	labels, err := renderVariables(b.Options.Labels, revision)
+	if err != nil {
+		return nil, fmt.Errorf("rendering labels: %w", err)
+	}
+	for _, label := range labels {
+		args = append(args, "--opt", "label:"+label)
+	}
+
+	for _, cache := range b.Options.CacheFrom {
+		args = append(args, "--import-cache", cache.importSpec())
+	}
+
+	for _, cache := range b.Options.CacheTo {
+		args = append(args, "--export-cache", cache.exportSpec())
+	}
+
+	output := "type=image,name=" + revision.Image + ":" + revision.Tag + ",push=true"
+
+	return append(args, "--output", output), nil
+}
@@ -0,0 +1,118 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const (
+	CacheInline   = "inline"
+	CacheRegistry = "registry"
+	CacheLocal    = "local"
+)
+
+// Cache is a build cache which is imported from or exported to a registry or a local
+// directory, or exported inline in the image built.
+type Cache struct {
+	Type string
+	// Location is the reference of a registry cache, or the path of a local cache
+	Location string
+}
+
+// ParseCaches parses caches of the form "inline", "registry=REF" or "local=PATH".
+func ParseCaches(specs []string) ([]Cache, error) {
+	caches := make([]Cache, 0, len(specs))
+	for _, spec := range specs {
+		cacheType, location, _ := strings.Cut(spec, "=")
+		switch {
+		case cacheType == CacheInline && location == "":
+		case (cacheType == CacheRegistry || cacheType == CacheLocal) && location != "":
+		default:
+			return nil, fmt.Errorf("parsing cache %q: expected inline, registry=REF or local=PATH", spec)
+		}
+
+		caches = append(caches, Cache{Type: cacheType, Location: location})
+	}
+
+	return caches, nil
+}
+
+// importSpec returns the cache as imported by buildx and buildctl.
+func (c Cache) importSpec() string {
+	switch c.Type {
+	case CacheRegistry:
+		return "type=registry,ref=" + c.Location
+	case CacheLocal:
+		return "type=local,src=" + c.Location
+	default:
+		return "type=" + c.Type
+	}
+}
+
+// exportSpec returns the cache as exported by buildx and buildctl. Registry and local
+// caches are exported with the layers of every stage, not just those of the final stage,
+// so that the layers of earlier stages, such as dependency installs, are reused.
+func (c Cache) exportSpec() string {
+	switch c.Type {
+	case CacheRegistry:
+		return "type=registry,ref=" + c.Location + ",mode=max"
+	case CacheLocal:
+		return "type=local,dest=" + c.Location + ",mode=max"
+	default:
+		return "type=" + c.Type
+	}
+}
+
+// CheckCaches checks that the caches of the options are supported by the builder of the kind.
+func (o Options) CheckCaches(kind string) error {
+	for _, cache := range o.CacheFrom {
+		// An inline cache is imported from the image it was exported in
+		if cache.Type == CacheInline {
+			return errors.New("an inline cache can not be imported from, import from the image it was exported in as a registry cache")
+		}
+	}
+
+	caches := append(append([]Cache(nil), o.CacheFrom...), o.CacheTo...)
+	switch kind {
+	case KindDocker:
+		// The default driver of Buildx, used by "docker build", can neither export to nor
+		// import from a local cache, nor export to a registry
+		for _, cache := range o.CacheFrom {
+			if cache.Type != CacheRegistry {
+				return fmt.Errorf("only registry caches can be imported from by the %s builder", kind)
+			}
+		}
+
+		for _, cache := range o.CacheTo {
+			if cache.Type != CacheInline {
+				return fmt.Errorf("only inline caches can be exported by the %s builder", kind)
+			}
+		}
+
+		return nil
+	case KindBuildKit:
+		return nil
+	case KindPodman, KindBuildah:
+		for _, cache := range caches {
+			if cache.Type != CacheRegistry {
+				return fmt.Errorf("only registry caches are supported by the %s builder", kind)
+			}
+
+			// The cache is stored as tags of the repository, so it may not be tagged itself
+			if _, err := name.NewRepository(cache.Location); err != nil {
+				return fmt.Errorf("registry cache of the %s builder must be a repository without a tag: %w", kind, err)
+			}
+		}
+
+		return nil
+	default:
+		if len(caches) > 0 {
+			return fmt.Errorf("caches are not supported by the %s builder", kind)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,159 @@
+package build
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCaches(t *testing.T) {
+	caches, err := ParseCaches([]string{"inline", "registry=registry.example.com/app:buildcache", "local=/var/cache/build"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := []Cache{
+		{Type: CacheInline},
+		{Type: CacheRegistry, Location: "registry.example.com/app:buildcache"},
+		{Type: CacheLocal, Location: "/var/cache/build"},
+	}
+	if !slices.Equal(caches, expected) {
+		t.Errorf("expected caches %+v, got %+v", expected, caches)
+	}
+}
+
+func TestParseCachesRejectsMalformed(t *testing.T) {
+	for _, spec := range []string{"registry", "local=", "inline=registry.example.com/app", "s3=bucket"} {
+		if _, err := ParseCaches([]string{spec}); err == nil {
+			t.Errorf("expected error for %q, got nil", spec)
+		}
+	}
+}
+
+func TestCachesArgs(t *testing.T) {
+	options := Options{
+		DockerfilePath: "docker/Dockerfile",
+		CacheFrom:      []Cache{{Type: CacheRegistry, Location: "registry.example.com/app:buildcache"}},
+		CacheTo:        []Cache{{Type: CacheLocal, Location: "/var/cache/build"}, {Type: CacheInline}},
+	}
+	revision := Revision{Image: "app", Tag: "abc"}
+
+	buildKitArgs, err := NewBuildKitCLIBuilder(options, discardLogger).args("src", revision)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := []string{
+		"build",
+		"--frontend", "dockerfile.v0",
+		"--local", "context=src",
+		"--local", "dockerfile=docker",
+		"--opt", "filename=Dockerfile",
+		"--import-cache", "type=registry,ref=registry.example.com/app:buildcache",
+		"--export-cache", "type=local,dest=/var/cache/build,mode=max",
+		"--export-cache", "type=inline",
+		"--output", "type=image,name=app:abc,push=true",
+	}
+	if !slices.Equal(buildKitArgs, expected) {
+		t.Errorf("expected buildctl args %q, got %q", expected, buildKitArgs)
+	}
+
+	options.CacheTo = []Cache{{Type: CacheInline}}
+	dockerArgs, err := NewDockerCLIBuilder(options, discardLogger).args("src", revision)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected = []string{
+		"build", "-f", "docker/Dockerfile", "-t", "app:abc",
+		"--cache-from", "type=registry,ref=registry.example.com/app:buildcache",
+		"--cache-to", "type=inline",
+		"src",
+	}
+	if !slices.Equal(dockerArgs, expected) {
+		t.Errorf("expected docker args %q, got %q", expected, dockerArgs)
+	}
+
+	options.CacheFrom = []Cache{{Type: CacheRegistry, Location: "registry.example.com/app-cache"}}
+	options.CacheTo = options.CacheFrom
+	podmanArgs, err := NewCLIBuilder("podman", options, discardLogger).args("src", revision)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected = []string{
+		"build", "-f", "docker/Dockerfile", "-t", "app:abc",
+		"--layers",
+		"--cache-from", "registry.example.com/app-cache",
+		"--cache-to", "registry.example.com/app-cache",
+		"src",
+	}
+	if !slices.Equal(podmanArgs, expected) {
+		t.Errorf("expected podman args %q, got %q", expected, podmanArgs)
+	}
+}
+
+func TestCheckCaches(t *testing.T) {
+	registry := Cache{Type: CacheRegistry, Location: "registry.example.com/app-cache"}
+	taggedRegistry := Cache{Type: CacheRegistry, Location: "registry.example.com/app:buildcache"}
+	local := Cache{Type: CacheLocal, Location: "/var/cache/build"}
+	inline := Cache{Type: CacheInline}
+
+	tests := []struct {
+		kind      string
+		options   Options
+		supported bool
+	}{
+		{KindDocker, Options{CacheFrom: []Cache{taggedRegistry}, CacheTo: []Cache{inline}}, true},
+		{KindBuildKit, Options{CacheFrom: []Cache{local}, CacheTo: []Cache{taggedRegistry}}, true},
+		{KindDocker, Options{CacheFrom: []Cache{inline}}, false},
+		{KindDocker, Options{CacheFrom: []Cache{local}}, false},
+		{KindDocker, Options{CacheTo: []Cache{taggedRegistry}}, false},
+		{KindDocker, Options{CacheTo: []Cache{local}}, false},
+		{KindPodman, Options{CacheFrom: []Cache{registry}, CacheTo: []Cache{registry}}, true},
+		{KindBuildah, Options{CacheTo: []Cache{taggedRegistry}}, false},
+		{KindBuildah, Options{CacheTo: []Cache{local}}, false},
+		{KindOCI, Options{}, true},
+		{KindOCI, Options{CacheTo: []Cache{inline}}, false},
+	}
+
+	for _, test := range tests {
+		err := test.options.CheckCaches(test.kind)
+		if test.supported && err != nil {
+			t.Errorf("expected caches %+v to be supported by %s builder, got %v", test.options, test.kind, err)
+		}
+		if !test.supported && err == nil {
+			t.Errorf("expected caches %+v not to be supported by %s builder, got nil error", test.options, test.kind)
+		}
+	}
+}
+
+func TestCheckDockerfile(t *testing.T) {
+	buildArgs, err := ParseVariables([]string{"COMMIT={{.Commit}}"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	tests := []struct {
+		kind      string
+		options   Options
+		supported bool
+	}{
+		{KindDocker, Options{DockerfilePath: "deploy/Dockerfile", BuildArgs: buildArgs, Target: "runtime"}, true},
+		{KindBuildKit, Options{DockerfileInContext: true}, true},
+		{KindOCI, Options{}, true},
+		{KindOCI, Options{DockerfilePath: "deploy/Dockerfile"}, false},
+		{KindOCI, Options{DockerfileInContext: true}, false},
+		{KindOCI, Options{BuildArgs: buildArgs}, false},
+		{KindOCI, Options{Target: "runtime"}, false},
+	}
+
+	for _, test := range tests {
+		err := test.options.CheckDockerfile(test.kind)
+		if test.supported && err != nil {
+			t.Errorf("expected options %+v to be supported by %s builder, got %v", test.options, test.kind, err)
+		}
+		if !test.supported && err == nil {
+			t.Errorf("expected options %+v not to be supported by %s builder, got nil error", test.options, test.kind)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// CLIBuilder builds images by using an external CLI binary whose build command is
+// compatible with that of docker, and which can run rootless and without a daemon, such
+// as podman or buildah. Only registry caches are supported, as their layers are cached
+// as tags of a repository.
+type CLIBuilder struct {
+	Command string
+	Options Options
+	Logger  *slog.Logger
+}
+
+func NewCLIBuilder(command string, options Options, logger *slog.Logger) *CLIBuilder {
+	return &CLIBuilder{
+		Command: command,
+		Options: options,
+		Logger:  logger,
+	}
+}
+
+func (b *CLIBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
+	/*
+		$command build \
+		-f docker/Dockerfile \
+		-t "${image_name}:${image_tag}" \
+		--target "$target" \
+		--platform "$platforms" \
+		--build-arg "$name=$value" \
+		--label "$name=$value" \
+		--layers \
+		--cache-from "$repository" \
+		--cache-to "$repository" \
+		"$src_dir"
+	*/
+
+	fullImageName := name + ":" + tag
+	b.Logger.InfoContext(ctx, "building image", "command", b.Command, "image", fullImageName)
+
+	args, err := b.args(buildContextPath, newRevision(name, tag, commit))
+	if err != nil {
+		return fmt.Errorf("preparing %s build command: %w", b.Command, err)
+	}
+
+	if err := runBuildCommand(ctx, b.Logger, b.Command, args, buildContextPath); err != nil {
+		return err
+	}
+	b.Logger.InfoContext(ctx, "image built", "image", fullImageName)
+
+	return nil
+}
+
+func (b *CLIBuilder) args(buildContextPath string, revision Revision) ([]string, error) {
+	args, err := dockerfileBuildArgs(b.Options, buildContextPath, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append(args, b.layerCacheArgs()...), buildContextPath), nil
+}
+
+// layerCacheArgs returns the arguments which cache the layers built, and import and
+// export them from and to registry caches.
+func (b *CLIBuilder) layerCacheArgs() []string {
+	if len(b.Options.CacheFrom) == 0 && len(b.Options.CacheTo) == 0 {
+		return nil
+	}
+
+	args := []string{"--layers"}
+	for _, cache := range b.Options.CacheFrom {
+		args = append(args, "--cache-from", cache.Location)
+	}
+
+	for _, cache := range b.Options.CacheTo {
+		args = append(args, "--cache-to", cache.Location)
+	}
+
+	return args
+}
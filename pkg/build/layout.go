@@ -0,0 +1,243 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+)
+
+const (
+	// refNameAnnotation is the annotation of the descriptors of an OCI layout naming the image.
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+	// createdAnnotation is the annotation of the descriptors of an OCI layout recording when
+	// the image was written.
+	createdAnnotation = "org.opencontainers.image.created"
+)
+
+// OCILayout is a directory in the OCI image layout format, holding the images built by
+// reference, i.e. "name:tag". It is safe for use by the builders and pushers sharing it.
+type OCILayout struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewOCILayout(path string) (*OCILayout, error) {
+	if path == "" {
+		return nil, errors.New("an OCI layout path is required")
+	}
+
+	return &OCILayout{
+		Path: path,
+	}, nil
+}
+
+// Write writes the index to the layout, replacing any of the same reference.
+func (l *OCILayout) Write(reference string, index v1.ImageIndex) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	path, err := l.open()
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{
+		refNameAnnotation: reference,
+		createdAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := path.ReplaceIndex(index, match.Name(reference), layout.WithAnnotations(annotations)); err != nil {
+		return fmt.Errorf("writing image %q to OCI layout %q: %w", reference, l.Path, err)
+	}
+
+	return nil
+}
+
+// Read reads the index of the reference from the layout.
+func (l *OCILayout) Read(reference string) (v1.ImageIndex, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	path, err := layout.FromPath(l.Path)
+	if err != nil {
+		return nil, fmt.Errorf("opening OCI layout %q: %w", l.Path, err)
+	}
+
+	root, err := path.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("reading index of OCI layout %q: %w", l.Path, err)
+	}
+
+	manifest, err := root.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading index of OCI layout %q: %w", l.Path, err)
+	}
+
+	for _, desc := range manifest.Manifests {
+		if desc.Annotations[refNameAnnotation] == reference {
+			index, err := root.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("reading image %q from OCI layout %q: %w", reference, l.Path, err)
+			}
+
+			return index, nil
+		}
+	}
+
+	return nil, fmt.Errorf("image %q not found in OCI layout %q", reference, l.Path)
+}
+
+// Prune removes the images written before the given time, unless it is zero, and then the
+// blobs no longer referenced by any image. It returns how many of each were removed.
+func (l *OCILayout) Prune(before time.Time) (int, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	path, err := layout.FromPath(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing has been built yet
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("opening OCI layout %q: %w", l.Path, err)
+	}
+
+	root, err := path.ImageIndex()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading index of OCI layout %q: %w", l.Path, err)
+	}
+
+	manifest, err := root.IndexManifest()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading index of OCI layout %q: %w", l.Path, err)
+	}
+
+	images := 0
+	if !before.IsZero() {
+		expired := func(desc v1.Descriptor) bool {
+			created, err := time.Parse(time.RFC3339, desc.Annotations[createdAnnotation])
+			return err == nil && created.Before(before)
+		}
+
+		for _, desc := range manifest.Manifests {
+			if expired(desc) {
+				images++
+			}
+		}
+
+		if err := path.RemoveDescriptors(expired); err != nil {
+			return 0, 0, fmt.Errorf("removing images from OCI layout %q: %w", l.Path, err)
+		}
+
+		// The index is read afresh, as it was rewritten without the images removed
+		if root, err = path.ImageIndex(); err != nil {
+			return 0, 0, fmt.Errorf("reading index of OCI layout %q: %w", l.Path, err)
+		}
+	}
+
+	referenced := make(map[v1.Hash]bool)
+	if err := referencedBlobs(root, referenced); err != nil {
+		return 0, 0, fmt.Errorf("reading images of OCI layout %q: %w", l.Path, err)
+	}
+
+	blobs := 0
+	blobsPath := filepath.Join(l.Path, "blobs")
+	err = filepath.WalkDir(blobsPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		// Blobs are stored as blobs/<algorithm>/<hex>
+		relPath, err := filepath.Rel(blobsPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		hash, err := v1.NewHash(strings.Replace(filepath.ToSlash(relPath), "/", ":", 1))
+		if err != nil || referenced[hash] {
+			// Files which are not blobs are left alone
+			return nil
+		}
+
+		if err := path.RemoveBlob(hash); err != nil {
+			return err
+		}
+		blobs++
+
+		return nil
+	})
+	if err != nil {
+		return images, blobs, fmt.Errorf("removing blobs from OCI layout %q: %w", l.Path, err)
+	}
+
+	return images, blobs, nil
+}
+
+// referencedBlobs adds the blobs referenced by the index to the set, including those of
+// the images and indexes it refers to.
+func referencedBlobs(index v1.ImageIndex, referenced map[v1.Hash]bool) error {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range manifest.Manifests {
+		referenced[desc.Digest] = true
+
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+
+			if err := referencedBlobs(child, referenced); err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			img, err := index.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+
+			configName, err := img.ConfigName()
+			if err != nil {
+				return err
+			}
+			referenced[configName] = true
+
+			imageManifest, err := img.Manifest()
+			if err != nil {
+				return err
+			}
+			for _, layer := range imageManifest.Layers {
+				referenced[layer.Digest] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// open opens the layout, creating it if it does not exist.
+func (l *OCILayout) open() (layout.Path, error) {
+	path, err := layout.FromPath(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		path, err = layout.Write(l.Path, empty.Index)
+	}
+	if err != nil {
+		return "", fmt.Errorf("opening OCI layout %q: %w", l.Path, err)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,235 @@
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// defaultPlatform is the platform built if none are configured.
+const defaultPlatform = "linux/amd64"
+
+// OCIBuilder builds images in-process, without a daemon, by appending a layer holding the
+// source code to a base image, and writes them to an OCI layout. No Dockerfile is used,
+// so only the labels and platforms of the options are honoured.
+type OCIBuilder struct {
+	BaseImage name.Reference
+	// SourcePath is the path of the source code to add, relative to the build context
+	SourcePath string
+	// DestPath is the absolute path at which the source code is added to the image
+	DestPath string
+	Layout   *OCILayout
+	Options  Options
+	Logger   *slog.Logger
+}
+
+func NewOCIBuilder(baseImage, sourcePath, destPath string,
+	layout *OCILayout,
+	options Options,
+	logger *slog.Logger) (*OCIBuilder, error) {
+	baseRef, err := name.ParseReference(baseImage)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base image %q: %w", baseImage, err)
+	}
+
+	if !path.IsAbs(destPath) {
+		return nil, fmt.Errorf("destination path %q is not absolute", destPath)
+	}
+
+	return &OCIBuilder{
+		BaseImage:  baseRef,
+		SourcePath: sourcePath,
+		DestPath:   destPath,
+		Layout:     layout,
+		Options:    options,
+		Logger:     logger,
+	}, nil
+}
+
+func (b *OCIBuilder) Build(ctx context.Context, buildContextPath, name, tag, commit string) error {
+	fullImageName := name + ":" + tag
+	b.Logger.InfoContext(ctx, "building OCI image", "image", fullImageName, "base_image", b.BaseImage.String())
+
+	labels, err := renderVariables(b.Options.Labels, newRevision(name, tag, commit))
+	if err != nil {
+		return fmt.Errorf("rendering labels: %w", err)
+	}
+
+	sourcePath := filepath.Join(buildContextPath, b.SourcePath)
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return tarDirectory(sourcePath, b.DestPath), nil
+	})
+	if err != nil {
+		return fmt.Errorf("creating layer from %q: %w", sourcePath, err)
+	}
+
+	platforms := b.Options.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{defaultPlatform}
+	}
+
+	// The image is always written as an index, even if only one platform is built
+	index := mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+	for _, platformName := range platforms {
+		platform, err := v1.ParsePlatform(platformName)
+		if err != nil {
+			return fmt.Errorf("parsing platform %q: %w", platformName, err)
+		}
+
+		img, err := b.buildImage(ctx, layer, platform, labels)
+		if err != nil {
+			return fmt.Errorf("building image for platform %q: %w", platformName, err)
+		}
+
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: platform},
+		})
+	}
+
+	if err := b.Layout.Write(fullImageName, index); err != nil {
+		return err
+	}
+	b.Logger.InfoContext(ctx, "image built", "image", fullImageName, "layout", b.Layout.Path)
+
+	return nil
+}
+
+func (b *OCIBuilder) buildImage(ctx context.Context,
+	layer v1.Layer,
+	platform *v1.Platform,
+	labels []string) (v1.Image, error) {
+	base, err := remote.Image(b.BaseImage,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithPlatform(*platform))
+	if err != nil {
+		return nil, fmt.Errorf("fetching base image %q: %w", b.BaseImage.String(), err)
+	}
+
+	img, err := mutate.AppendLayers(base, layer)
+	if err != nil {
+		return nil, fmt.Errorf("appending source code layer: %w", err)
+	}
+
+	if len(labels) == 0 {
+		return img, nil
+	}
+
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("reading image config: %w", err)
+	}
+
+	config := *configFile.Config.DeepCopy()
+	if config.Labels == nil {
+		config.Labels = make(map[string]string, len(labels))
+	}
+	for _, label := range labels {
+		labelName, value, _ := strings.Cut(label, "=")
+		config.Labels[labelName] = value
+	}
+
+	img, err = mutate.Config(img, config)
+	if err != nil {
+		return nil, fmt.Errorf("setting image labels: %w", err)
+	}
+
+	return img, nil
+}
+
+// tarDirectory streams a tar archive of the directory, with its contents placed under the
+// destination path. The archive is reproducible, as modification times and ownership are
+// not preserved. Git metadata is skipped.
+func tarDirectory(dirPath, destPath string) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if entry.IsDir() && entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			relPath, err := filepath.Rel(dirPath, filePath)
+			if err != nil {
+				return err
+			}
+
+			return addTarEntry(tw, filePath, path.Join(destPath, filepath.ToSlash(relPath)), entry)
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader
+}
+
+func addTarEntry(tw *tar.Writer, filePath, entryPath string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("creating tar header for %q: %w", filePath, err)
+	}
+	header.Name = strings.TrimPrefix(entryPath, "/") // Entries are relative to the root of the image
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	header.ModTime = time.Unix(0, 0)
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("writing tar header for %q: %w", filePath, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("writing %q to tar: %w", filePath, err)
+	}
+
+	return nil
+}
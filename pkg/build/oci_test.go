@@ -0,0 +1,206 @@
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestOCIBuilderBuild(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	baseImage := strings.TrimPrefix(server.URL, "http://") + "/base:latest"
+	baseRef, err := name.ParseReference(baseImage)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	base, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if err := remote.Write(baseRef, base); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	contextPath := t.TempDir()
+	for filePath, content := range map[string]string{
+		"dist/index.html":     "<html></html>",
+		"dist/js/app.js":      "console.log('app')",
+		"dist/.git/HEAD":      "ref: refs/heads/main",
+		"README.md":           "not in the source path",
+		"dist/css/style.css":  "body {}",
+		"dist/img/.gitignore": "*.tmp",
+	} {
+		fullPath := filepath.Join(contextPath, filePath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0o644); err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+
+	labels, err := ParseVariables([]string{"org.opencontainers.image.revision={{.Commit}}"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	layout, err := NewOCILayout(filepath.Join(t.TempDir(), "layout"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	b, err := NewOCIBuilder(baseImage, "dist", "/usr/share/nginx/html", layout, Options{Labels: labels}, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	if err := b.Build(context.Background(), contextPath, "registry.example.com/app", "v1.0.0", commit); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	index, err := layout.Read("registry.example.com/app:v1.0.0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if len(manifest.Manifests) != 1 {
+		t.Fatalf("expected 1 manifest, got %d", len(manifest.Manifests))
+	}
+
+	desc := manifest.Manifests[0]
+	if desc.Platform == nil || desc.Platform.String() != defaultPlatform {
+		t.Errorf("expected platform %q, got %v", defaultPlatform, desc.Platform)
+	}
+
+	img, err := index.Image(desc.Digest)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if len(layers) != 2 {
+		t.Errorf("expected 2 layers, got %d", len(layers))
+	}
+
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if revision := configFile.Config.Labels["org.opencontainers.image.revision"]; revision != commit {
+		t.Errorf("expected revision label %q, got %q", commit, revision)
+	}
+
+	files := imageFiles(t, img)
+	for filePath, content := range map[string]string{
+		"usr/share/nginx/html/index.html":     "<html></html>",
+		"usr/share/nginx/html/js/app.js":      "console.log('app')",
+		"usr/share/nginx/html/css/style.css":  "body {}",
+		"usr/share/nginx/html/img/.gitignore": "*.tmp",
+	} {
+		if files[filePath] != content {
+			t.Errorf("expected file %q to have content %q, got %q", filePath, content, files[filePath])
+		}
+	}
+
+	for _, filePath := range []string{"usr/share/nginx/html/.git/HEAD", "usr/share/nginx/html/README.md"} {
+		if _, ok := files[filePath]; ok {
+			t.Errorf("expected file %q not to be in image", filePath)
+		}
+	}
+}
+
+func TestOCIBuilderBuildIsReproducible(t *testing.T) {
+	contextPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(contextPath, "index.js"), []byte("console.log('app')"), 0o644); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	archive := func() string {
+		content, err := io.ReadAll(tarDirectory(contextPath, "/app"))
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		return string(content)
+	}
+
+	first := archive()
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(contextPath, "index.js"), modified, modified); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if second := archive(); first != second {
+		t.Error("expected identical archives of unchanged source code")
+	}
+}
+
+func TestNewOCIBuilderRelativeDestPath(t *testing.T) {
+	layout, err := NewOCILayout(t.TempDir())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if _, err := NewOCIBuilder("alpine:3", ".", "app", layout, Options{}, discardLogger); err == nil {
+		t.Error("expected error for relative destination path, got nil")
+	}
+}
+
+// imageFiles returns the content of the regular files of the flattened image.
+func imageFiles(t *testing.T, img v1.Image) map[string]string {
+	t.Helper()
+
+	reader := mutate.Extract(img)
+	defer reader.Close()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		files[header.Name] = string(content)
+	}
+}
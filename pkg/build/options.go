@@ -0,0 +1,134 @@
+package build
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultDockerfilePath is the path of the Dockerfile if none is given.
+const DefaultDockerfilePath = "docker/Dockerfile"
+
+// Options configures how images are built.
+type Options struct {
+	// DockerfilePath is the path of the Dockerfile, relative to the working directory or,
+	// if DockerfileInContext is set, to the build context. If empty, DefaultDockerfilePath is used.
+	DockerfilePath      string
+	DockerfileInContext bool
+	BuildArgs           []Variable
+	Target              string
+	Labels              []Variable
+	Platforms           []string
+	CacheFrom           []Cache
+	CacheTo             []Cache
+}
+
+// Dockerfile returns the path of the Dockerfile used to build the context.
+func (o Options) Dockerfile(buildContextPath string) string {
+	dockerfilePath := o.DockerfilePath
+	if dockerfilePath == "" {
+		dockerfilePath = DefaultDockerfilePath
+	}
+
+	if o.DockerfileInContext {
+		return filepath.Join(buildContextPath, dockerfilePath)
+	}
+
+	return dockerfilePath
+}
+
+// CheckDockerfile checks that the options of the Dockerfile are only given if the builder
+// of the kind uses one, rather than being ignored.
+func (o Options) CheckDockerfile(kind string) error {
+	if kind != KindOCI {
+		return nil
+	}
+
+	switch {
+	case o.DockerfilePath != "" || o.DockerfileInContext:
+		return fmt.Errorf("a Dockerfile is not used by the %s builder", kind)
+	case len(o.BuildArgs) > 0:
+		return fmt.Errorf("build args are not supported by the %s builder", kind)
+	case o.Target != "":
+		return fmt.Errorf("build targets are not supported by the %s builder", kind)
+	}
+
+	return nil
+}
+
+// Variable is a named value, such as a build arg or label, whose value is a template
+// rendered with the Revision being built.
+type Variable struct {
+	Name  string
+	Value *template.Template
+}
+
+// ParseVariables parses variables of the form "NAME=VALUE", where the value is a template,
+// e.g. "COMMIT={{.Commit}}".
+func ParseVariables(pairs []string) ([]Variable, error) {
+	variables := make([]Variable, 0, len(pairs))
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("parsing variable %q: expected NAME=VALUE", pair)
+		}
+
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template of variable %q: %w", name, err)
+		}
+
+		variables = append(variables, Variable{Name: name, Value: tmpl})
+	}
+
+	return variables, nil
+}
+
+// Revision is what is being built, as available to the templates of variables.
+type Revision struct {
+	Image     string
+	Tag       string
+	Commit    string
+	BuildTime time.Time
+}
+
+// newRevision returns the revision of the commit being built now.
+func newRevision(name, tag, commit string) Revision {
+	return Revision{
+		Image:     name,
+		Tag:       tag,
+		Commit:    commit,
+		BuildTime: time.Now(),
+	}
+}
+
+// ShortCommit returns the abbreviated commit hash.
+func (r Revision) ShortCommit() string {
+	if len(r.Commit) > 7 {
+		return r.Commit[:7]
+	}
+
+	return r.Commit
+}
+
+// BuildTimestamp returns the build time formatted as RFC 3339, in UTC.
+func (r Revision) BuildTimestamp() string {
+	return r.BuildTime.UTC().Format(time.RFC3339)
+}
+
+// renderVariables renders the variables as "NAME=VALUE" pairs.
+func renderVariables(variables []Variable, revision Revision) ([]string, error) {
+	pairs := make([]string, 0, len(variables))
+	for _, variable := range variables {
+		value := new(strings.Builder)
+		if err := variable.Value.Execute(value, revision); err != nil {
+			return nil, fmt.Errorf("rendering variable %q: %w", variable.Name, err)
+		}
+
+		pairs = append(pairs, variable.Name+"="+value.String())
+	}
+
+	return pairs, nil
+}
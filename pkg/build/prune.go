@@ -0,0 +1,176 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
+)
+
+// Pruner removes what builds leave behind on the build host, such as dangling images and
+// build cache, so that it does not fill up.
+type Pruner interface {
+	Prune(ctx context.Context) error
+}
+
+// PrunePolicy is what is kept when pruning.
+type PrunePolicy struct {
+	// KeepDuration is how long what was last used within is kept. Zero means no limit.
+	KeepDuration time.Duration
+	// KeepStorageMB is how much build cache, in megabytes, is kept. Zero means no limit.
+	KeepStorageMB int
+}
+
+// CLIPruner prunes by running the prune commands of a CLI in turn.
+type CLIPruner struct {
+	Command string
+	Args    [][]string
+	Logger  *slog.Logger
+}
+
+// NewDockerCLIPruner returns a pruner which removes dangling images and build cache.
+func NewDockerCLIPruner(policy PrunePolicy, logger *slog.Logger) *CLIPruner {
+	/*
+		docker image prune -f --filter "until=$keep_duration"
+		docker builder prune -f --filter "until=$keep_duration" --keep-storage "${keep_storage}MB"
+	*/
+
+	imageArgs := []string{"image", "prune", "-f"}
+	builderArgs := []string{"builder", "prune", "-f"}
+	if policy.KeepDuration > 0 {
+		imageArgs = append(imageArgs, "--filter", "until="+policy.KeepDuration.String())
+		builderArgs = append(builderArgs, "--filter", "until="+policy.KeepDuration.String())
+	}
+
+	if policy.KeepStorageMB > 0 {
+		builderArgs = append(builderArgs, "--keep-storage", strconv.Itoa(policy.KeepStorageMB)+"MB")
+	}
+
+	return &CLIPruner{
+		Command: "docker",
+		Args:    [][]string{imageArgs, builderArgs},
+		Logger:  logger,
+	}
+}
+
+// NewPodmanCLIPruner returns a pruner which removes dangling images, and so the layers
+// cached by builds which are no longer used. The storage kept can not be limited.
+func NewPodmanCLIPruner(policy PrunePolicy, logger *slog.Logger) (*CLIPruner, error) {
+	/*
+		podman image prune -f --filter "until=$keep_duration"
+	*/
+
+	if policy.KeepStorageMB > 0 {
+		return nil, errors.New("limiting the storage kept is not supported by the podman pruner")
+	}
+
+	args := []string{"image", "prune", "-f"}
+	if policy.KeepDuration > 0 {
+		args = append(args, "--filter", "until="+policy.KeepDuration.String())
+	}
+
+	return &CLIPruner{
+		Command: "podman",
+		Args:    [][]string{args},
+		Logger:  logger,
+	}, nil
+}
+
+// NewBuildahCLIPruner returns a pruner which removes dangling images, and so the layers
+// cached by builds which are no longer used. Neither the age nor storage kept can be limited.
+func NewBuildahCLIPruner(policy PrunePolicy, logger *slog.Logger) (*CLIPruner, error) {
+	/*
+		buildah rmi --prune
+	*/
+
+	if policy.KeepDuration > 0 || policy.KeepStorageMB > 0 {
+		return nil, errors.New("limiting the age or storage kept is not supported by the buildah pruner")
+	}
+
+	return &CLIPruner{
+		Command: "buildah",
+		Args:    [][]string{{"rmi", "--prune"}},
+		Logger:  logger,
+	}, nil
+}
+
+// NewBuildKitCLIPruner returns a pruner which removes the build cache of buildkitd.
+func NewBuildKitCLIPruner(policy PrunePolicy, logger *slog.Logger) *CLIPruner {
+	/*
+		buildctl prune --keep-duration "$keep_duration" --keep-storage "$keep_storage"
+	*/
+
+	args := []string{"prune"}
+	if policy.KeepDuration > 0 {
+		args = append(args, "--keep-duration", policy.KeepDuration.String())
+	}
+
+	if policy.KeepStorageMB > 0 {
+		args = append(args, "--keep-storage", strconv.Itoa(policy.KeepStorageMB))
+	}
+
+	return &CLIPruner{
+		Command: "buildctl",
+		Args:    [][]string{args},
+		Logger:  logger,
+	}
+}
+
+func (p *CLIPruner) Prune(ctx context.Context) error {
+	p.Logger.InfoContext(ctx, "pruning build host", "command", p.Command)
+
+	for _, args := range p.Args {
+		cmd := executil.Command(ctx, p.Command, args...)
+
+		p.Logger.InfoContext(ctx, "executing command", "command", cmd.String())
+		if err := executil.Run(ctx, p.Logger, cmd); err != nil {
+			return fmt.Errorf("running %s prune command: %w", p.Command, err)
+		}
+	}
+	p.Logger.InfoContext(ctx, "build host pruned", "command", p.Command)
+
+	return nil
+}
+
+// OCILayoutPruner prunes the images of an OCI layout.
+type OCILayoutPruner struct {
+	Layout *OCILayout
+	Policy PrunePolicy
+	Logger *slog.Logger
+}
+
+// NewOCILayoutPruner returns a pruner which removes the images written to the layout
+// longer ago than the keep duration, and the blobs of images no longer in the layout, such
+// as those replaced by rebuilding. The storage kept can not be limited.
+func NewOCILayoutPruner(layout *OCILayout, policy PrunePolicy, logger *slog.Logger) (*OCILayoutPruner, error) {
+	if policy.KeepStorageMB > 0 {
+		return nil, errors.New("limiting the storage kept is not supported by the OCI layout pruner")
+	}
+
+	return &OCILayoutPruner{
+		Layout: layout,
+		Policy: policy,
+		Logger: logger,
+	}, nil
+}
+
+func (p *OCILayoutPruner) Prune(ctx context.Context) error {
+	p.Logger.InfoContext(ctx, "pruning OCI layout", "layout", p.Layout.Path)
+
+	var before time.Time
+	if p.Policy.KeepDuration > 0 {
+		before = time.Now().Add(-p.Policy.KeepDuration)
+	}
+
+	images, blobs, err := p.Layout.Prune(before)
+	if err != nil {
+		return err
+	}
+	p.Logger.InfoContext(ctx, "OCI layout pruned", "layout", p.Layout.Path, "images", images, "blobs", blobs)
+
+	return nil
+}
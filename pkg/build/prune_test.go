@@ -0,0 +1,167 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestCLIPrunersArgs(t *testing.T) {
+	policy := PrunePolicy{KeepDuration: 24 * time.Hour, KeepStorageMB: 10240}
+
+	podman, err := NewPodmanCLIPruner(PrunePolicy{KeepDuration: policy.KeepDuration}, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	buildah, err := NewBuildahCLIPruner(PrunePolicy{}, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	tests := []struct {
+		pruner   *CLIPruner
+		expected [][]string
+	}{
+		{NewDockerCLIPruner(policy, discardLogger), [][]string{
+			{"image", "prune", "-f", "--filter", "until=24h0m0s"},
+			{"builder", "prune", "-f", "--filter", "until=24h0m0s", "--keep-storage", "10240MB"},
+		}},
+		{NewDockerCLIPruner(PrunePolicy{}, discardLogger), [][]string{
+			{"image", "prune", "-f"},
+			{"builder", "prune", "-f"},
+		}},
+		{podman, [][]string{{"image", "prune", "-f", "--filter", "until=24h0m0s"}}},
+		{buildah, [][]string{{"rmi", "--prune"}}},
+		{NewBuildKitCLIPruner(policy, discardLogger), [][]string{
+			{"prune", "--keep-duration", "24h0m0s", "--keep-storage", "10240"},
+		}},
+	}
+
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.pruner.Args, test.expected) {
+			t.Errorf("expected %s prune args %q, got %q", test.pruner.Command, test.expected, test.pruner.Args)
+		}
+	}
+}
+
+func TestCLIPrunersRejectUnsupportedPolicy(t *testing.T) {
+	if _, err := NewPodmanCLIPruner(PrunePolicy{KeepStorageMB: 1024}, discardLogger); err == nil {
+		t.Error("expected error limiting storage kept by podman pruner, got nil")
+	}
+
+	if _, err := NewBuildahCLIPruner(PrunePolicy{KeepDuration: time.Hour}, discardLogger); err == nil {
+		t.Error("expected error limiting age kept by buildah pruner, got nil")
+	}
+}
+
+func TestCLIPrunerPrune(t *testing.T) {
+	argsPath := fakeExecutable(t, "buildctl", 0)
+
+	if err := NewBuildKitCLIPruner(PrunePolicy{KeepDuration: time.Hour}, discardLogger).Prune(context.Background()); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := []string{"prune", "--keep-duration", "1h0m0s"}
+	if args := readArgs(t, argsPath); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected args %q, got %q", expected, args)
+	}
+
+	fakeExecutable(t, "buildctl", 1)
+	if err := NewBuildKitCLIPruner(PrunePolicy{}, discardLogger).Prune(context.Background()); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestOCILayoutPrune(t *testing.T) {
+	layout, err := NewOCILayout(filepath.Join(t.TempDir(), "layout"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Pruning before anything is built does nothing
+	if images, blobs, err := layout.Prune(time.Now()); err != nil || images != 0 || blobs != 0 {
+		t.Fatalf("expected nothing to be pruned, got %d images, %d blobs and error %v", images, blobs, err)
+	}
+
+	for _, reference := range []string{"app:old", "app:new", "app:new"} {
+		index, err := random.Index(64, 1, 1)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		if err := layout.Write(reference, index); err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+
+	// The blobs of the image replaced by rebuilding "app:new" are no longer referenced:
+	// its index, manifest, config and layer
+	images, blobs, err := layout.Prune(time.Time{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if images != 0 || blobs != 4 {
+		t.Errorf("expected 0 images and 4 blobs to be pruned, got %d images and %d blobs", images, blobs)
+	}
+
+	for _, reference := range []string{"app:old", "app:new"} {
+		if _, err := layout.Read(reference); err != nil {
+			t.Errorf("expected image %q to be kept, got %v", reference, err)
+		}
+	}
+
+	ageImage(t, layout, "app:old", 24*time.Hour)
+
+	images, blobs, err = layout.Prune(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if images != 1 || blobs != 4 {
+		t.Errorf("expected 1 image and 4 blobs to be pruned, got %d images and %d blobs", images, blobs)
+	}
+
+	if _, err := layout.Read("app:old"); err == nil {
+		t.Error("expected image \"app:old\" to be pruned, but was read")
+	}
+	if _, err := layout.Read("app:new"); err != nil {
+		t.Errorf("expected image \"app:new\" to be kept, got %v", err)
+	}
+}
+
+// ageImage rewrites the index of the layout as if the image had been written the duration ago.
+func ageImage(t *testing.T, layout *OCILayout, reference string, age time.Duration) {
+	t.Helper()
+
+	indexPath := filepath.Join(layout.Path, "index.json")
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	var manifest v1.IndexManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	for _, desc := range manifest.Manifests {
+		if desc.Annotations[refNameAnnotation] == reference {
+			desc.Annotations[createdAnnotation] = time.Now().Add(-age).UTC().Format(time.RFC3339)
+		}
+	}
+
+	if content, err = json.Marshal(manifest); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if err := os.WriteFile(indexPath, content, 0o644); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+}
@@ -1,37 +1,70 @@
 package check
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 
 	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
 )
 
 type Checker interface {
-	Check() (bool, error)
+	Check(ctx context.Context) (bool, error)
 }
 
 type GitChecker struct {
-	Path         string
-	RemoteBranch string
+	Path       string
+	Selector   gitutil.RefSelector
+	Submodules bool
+	Auth       gitauth.Provider
+	Logger     *slog.Logger
 }
 
-func NewGitChecker(path, remoteBranch string) *GitChecker {
+func NewGitChecker(path string,
+	selector gitutil.RefSelector,
+	submodules bool,
+	auth gitauth.Provider,
+	logger *slog.Logger) *GitChecker {
 	return &GitChecker{
-		Path:         path,
-		RemoteBranch: remoteBranch,
+		Path:       path,
+		Selector:   selector,
+		Submodules: submodules,
+		Auth:       auth,
+		Logger:     logger,
 	}
 }
 
-func (c *GitChecker) Check() (bool, error) {
-	localHash, err := gitutil.GetLocalGitHeadHash(c.Path)
+func (c *GitChecker) Check(ctx context.Context) (bool, error) {
+	localHash, err := gitutil.GetLocalGitHeadHash(ctx, c.Logger, c.Path)
 	if err != nil {
 		return false, fmt.Errorf("getting local git hash: %w", err)
 	}
 
-	remoteHash, err := gitutil.GetRemoteHeadHash(c.Path, c.RemoteBranch)
+	auth, err := c.Auth.AuthMethod(ctx)
+	if err != nil {
+		return false, fmt.Errorf("getting git authentication: %w", err)
+	}
+
+	remoteHash, err := gitutil.GetRemoteHeadHash(ctx, c.Logger, auth, c.Path, c.Selector)
 	if err != nil {
 		return false, fmt.Errorf("getting remote git hash: %w", err)
 	}
 
-	return localHash != remoteHash, nil
+	if localHash != remoteHash {
+		return true, nil
+	}
+
+	// A submodule may not have been updated to the commit recorded for it by a change to
+	// its pointer, such as if the update was interrupted
+	if c.Submodules {
+		inSync, err := gitutil.SubmodulesInSync(ctx, c.Logger, c.Path)
+		if err != nil {
+			return false, fmt.Errorf("checking git submodules: %w", err)
+		}
+
+		return !inSync, nil
+	}
+
+	return false, nil
 }
@@ -0,0 +1,79 @@
+package check
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-c", "protocol.file.allow=always"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("running git %v: %v: %s", args, err, output)
+	}
+}
+
+func TestGitCheckerDetectsSubmoduleDrift(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git CLI not available")
+	}
+
+	subPath := t.TempDir()
+	runGit(t, subPath, "init", "-b", "main")
+	runGit(t, subPath, "commit", "--allow-empty", "-m", "first")
+
+	remotePath := t.TempDir()
+	runGit(t, remotePath, "init", "-b", "main")
+	runGit(t, remotePath, "submodule", "add", subPath, "assets")
+	runGit(t, remotePath, "commit", "-m", "add submodule")
+
+	// Clone without initialising the submodule, so it is out of sync
+	destPath := filepath.Join(t.TempDir(), "src")
+	runGit(t, filepath.Dir(destPath), "clone", remotePath, destPath)
+
+	checker := NewGitChecker(destPath, gitutil.NewBranchSelector("main"), false, gitauth.NewAnonymousProvider(), discardLogger)
+	changed, err := checker.Check(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if changed {
+		t.Error("expected no change when submodules are not checked")
+	}
+
+	checker = NewGitChecker(destPath, gitutil.NewBranchSelector("main"), true, gitauth.NewAnonymousProvider(), discardLogger)
+	changed, err = checker.Check(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !changed {
+		t.Error("expected change when submodule is out of sync")
+	}
+
+	runGit(t, destPath, "submodule", "update", "--init")
+
+	changed, err = checker.Check(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if changed {
+		t.Error("expected no change once submodule is in sync")
+	}
+}
@@ -0,0 +1,25 @@
+package exec
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// terminateGracePeriod is how long a cancelled command is given to exit after being
+// asked to terminate, before it is killed.
+const terminateGracePeriod = 10 * time.Second
+
+// Command returns a command which is terminated if the context is cancelled.
+// Rather than being killed outright, the process is first sent SIGTERM so that it has the
+// opportunity to clean up (e.g. so Helm can roll back an atomic upgrade).
+func Command(ctx context.Context, name string, arg ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = terminateGracePeriod
+
+	return cmd
+}
@@ -0,0 +1,177 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	// stderrTailLines is the number of lines of stderr retained for inclusion in errors.
+	stderrTailLines = 20
+
+	// maxLineLength is the length beyond which lines of output are truncated when retained.
+	maxLineLength = 512
+)
+
+type outputKey struct{}
+
+// WithOutput returns a context which causes the output of commands run with it to be
+// written to the given writer, in addition to being logged.
+func WithOutput(ctx context.Context, output io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, output)
+}
+
+func outputFrom(ctx context.Context) io.Writer {
+	output, _ := ctx.Value(outputKey{}).(io.Writer)
+	return output
+}
+
+// Error is returned when a command fails, and includes the tail of its stderr.
+type Error struct {
+	Err        error
+	StderrTail []string
+}
+
+func (e *Error) Error() string {
+	if len(e.StderrTail) == 0 {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%v, stderr:\n%s", e.Err, strings.Join(e.StderrTail, "\n"))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Run runs the command, logging each line of its stdout and stderr as it is produced.
+// If the command fails, the returned error includes the last lines of its stderr.
+func Run(ctx context.Context, logger *slog.Logger, cmd *exec.Cmd) error {
+	stdout := newLineWriter(ctx, logger.With("stream", "stdout"), 0)
+	stderr := newLineWriter(ctx, logger.With("stream", "stderr"), stderrTailLines)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// Both streams are written to the same output, so that it is in the order produced
+	if output := outputFrom(ctx); output != nil {
+		output = &syncWriter{w: output, ctx: ctx, logger: logger}
+		cmd.Stdout = io.MultiWriter(stdout, output)
+		cmd.Stderr = io.MultiWriter(stderr, output)
+	}
+
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	if err != nil {
+		return &Error{
+			Err:        err,
+			StderrTail: stderr.tail(),
+		}
+	}
+
+	return nil
+}
+
+// lineWriter logs each complete line written to it, retaining the last lines written.
+type lineWriter struct {
+	ctx       context.Context
+	logger    *slog.Logger
+	tailLines int
+
+	mu      sync.Mutex
+	partial []byte
+	lines   []string
+}
+
+func newLineWriter(ctx context.Context, logger *slog.Logger, tailLines int) *lineWriter {
+	return &lineWriter{
+		ctx:       ctx,
+		logger:    logger,
+		tailLines: tailLines,
+	}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// flush logs any remaining output which was not terminated by a newline.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *lineWriter) tail() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]string(nil), w.lines...)
+}
+
+func (w *lineWriter) line(line string) {
+	line = strings.TrimRight(line, "\r")
+	w.logger.InfoContext(w.ctx, line)
+
+	if w.tailLines == 0 {
+		return
+	}
+
+	if len(line) > maxLineLength {
+		line = line[:maxLineLength] + "..."
+	}
+	w.lines = append(w.lines, line)
+	if len(w.lines) > w.tailLines {
+		w.lines = w.lines[len(w.lines)-w.tailLines:]
+	}
+}
+
+// syncWriter serialises writes from the stdout and stderr copying goroutines.
+// Failure to write is reported once but otherwise ignored, so as not to fail the command.
+type syncWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	ctx    context.Context
+	logger *slog.Logger
+	failed bool
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failed {
+		return len(p), nil
+	}
+
+	if _, err := w.w.Write(p); err != nil {
+		w.logger.WarnContext(w.ctx, "error writing command output, further output will not be written", "error", err)
+		w.failed = true
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,52 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRunIncludesStderrTailInError(t *testing.T) {
+	// Write more lines to stderr than are retained, interleaved with stdout
+	script := fmt.Sprintf(`for i in $(seq 1 %d); do echo "out $i"; echo "err $i" >&2; done; exit 3`,
+		stderrTailLines+5)
+	cmd := exec.Command("sh", "-c", script)
+
+	err := Run(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), cmd)
+
+	execErr := new(Error)
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected error of type %T, got %q (of type %T)", execErr, err, err)
+	}
+
+	exitErr := new(exec.ExitError)
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("expected exit status 3 to be wrapped, got %q", err)
+	}
+
+	if len(execErr.StderrTail) != stderrTailLines {
+		t.Fatalf("expected %d lines of stderr to be retained, got %d", stderrTailLines, len(execErr.StderrTail))
+	}
+
+	lastLine := fmt.Sprintf("err %d", stderrTailLines+5)
+	if execErr.StderrTail[len(execErr.StderrTail)-1] != lastLine {
+		t.Errorf("expected last retained line to be %q, got %q", lastLine, execErr.StderrTail[len(execErr.StderrTail)-1])
+	}
+
+	if strings.Contains(err.Error(), "out ") {
+		t.Errorf("expected stdout to not be included in error, got %q", err)
+	}
+}
+
+func TestRunSucceeds(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "echo warning >&2")
+
+	if err := Run(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), cmd); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+}
@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+)
+
+// Filter determines whether a change between revisions is relevant to what is deployed,
+// and so is to be built and installed.
+type Filter interface {
+	Relevant(ctx context.Context, from, to string) (bool, error)
+}
+
+// GitPathFilter considers a change relevant if any of the files it changes are included
+// and not excluded by the glob patterns. If there are no include patterns, every file is
+// included.
+//
+// A pattern containing no "/" matches any file with a matching name, or within a directory
+// with a matching name, e.g. "*.md" or "docs". Otherwise, it matches a file whose path from
+// the root of the repository matches, or which is within a directory whose path matches,
+// e.g. "app/*.go" or "chart/templates".
+type GitPathFilter struct {
+	Path    string
+	Include []string
+	Exclude []string
+	Logger  *slog.Logger
+}
+
+func NewGitPathFilter(path string, include, exclude []string, logger *slog.Logger) (*GitPathFilter, error) {
+	for _, pattern := range append(append([]string(nil), include...), exclude...) {
+		if err := checkPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return &GitPathFilter{
+		Path:    path,
+		Include: include,
+		Exclude: exclude,
+		Logger:  logger,
+	}, nil
+}
+
+// ForPath returns a filter with the same patterns of the repository at the given path.
+func (f *GitPathFilter) ForPath(path string) *GitPathFilter {
+	return &GitPathFilter{
+		Path:    path,
+		Include: f.Include,
+		Exclude: f.Exclude,
+		Logger:  f.Logger,
+	}
+}
+
+func (f *GitPathFilter) Relevant(ctx context.Context, from, to string) (bool, error) {
+	paths, err := gitutil.ChangedPaths(ctx, f.Path, from, to)
+	if err != nil {
+		return false, fmt.Errorf("getting changed paths: %w", err)
+	}
+
+	for _, changed := range paths {
+		if f.Matches(changed) {
+			f.Logger.InfoContext(ctx, "changed path matches filters", "path", changed)
+			return true, nil
+		}
+	}
+
+	f.Logger.InfoContext(ctx, "no changed path matches filters", "changed", len(paths))
+	return false, nil
+}
+
+// Matches returns whether the file at the path, relative to the root of the repository,
+// is included and not excluded.
+func (f *GitPathFilter) Matches(filePath string) bool {
+	included := len(f.Include) == 0
+	for _, pattern := range f.Include {
+		if matchPattern(pattern, filePath) {
+			included = true
+			break
+		}
+	}
+
+	if !included {
+		return false
+	}
+
+	for _, pattern := range f.Exclude {
+		if matchPattern(pattern, filePath) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("parsing path pattern %q: %w", pattern, err)
+	}
+
+	return nil
+}
+
+// matchPattern returns whether the pattern matches the file or any directory containing it.
+func matchPattern(pattern, filePath string) bool {
+	pattern = strings.Trim(pattern, "/")
+	elements := strings.Split(filePath, "/")
+
+	// A pattern of a name alone matches any element of the path
+	if !strings.Contains(pattern, "/") {
+		for _, element := range elements {
+			if matched, _ := path.Match(pattern, element); matched {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for i := range elements {
+		if matched, _ := path.Match(pattern, strings.Join(elements[:i+1], "/")); matched {
+			return true
+		}
+	}
+
+	return false
+}
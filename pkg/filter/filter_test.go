@@ -0,0 +1,116 @@
+package filter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestGitPathFilterMatches(t *testing.T) {
+	f, err := NewGitPathFilter("", []string{"app", "docker/Dockerfile", "chart/*/values.yaml"}, []string{"*.md"}, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	for filePath, want := range map[string]bool{
+		"app/main.go":              true,
+		"app/README.md":            false,
+		"app/src/index.js":         true,
+		"docker/Dockerfile":        true,
+		"docker/compose.yaml":      false,
+		"chart/demo/values.yaml":   true,
+		"chart/demo/Chart.yaml":    false,
+		"README.md":                false,
+		"other/app/unrelated.json": true,
+	} {
+		if got := f.Matches(filePath); got != want {
+			t.Errorf("expected %v for %q, got %v", want, filePath, got)
+		}
+	}
+}
+
+func TestGitPathFilterIncludesAllWithoutIncludePatterns(t *testing.T) {
+	f, err := NewGitPathFilter("", nil, []string{"docs/*"}, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !f.Matches("app/main.go") {
+		t.Error("expected file to be included")
+	}
+
+	if f.Matches("docs/guide/index.md") {
+		t.Error("expected file to be excluded")
+	}
+}
+
+func TestNewGitPathFilterRejectsBadPattern(t *testing.T) {
+	if _, err := NewGitPathFilter("", []string{"app/["}, nil, discardLogger); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func commitFile(t *testing.T, repo *git.Repository, path, name, contents string) gitplumbing.Hash {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0o755); err != nil {
+		t.Fatalf("creating directory: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, name), []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("getting worktree: %v", err)
+	}
+
+	if _, err := worktree.Add(name); err != nil {
+		t.Fatalf("adding file: %v", err)
+	}
+
+	hash, err := worktree.Commit("update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("committing: %v", err)
+	}
+
+	return hash
+}
+
+func TestGitPathFilterRelevant(t *testing.T) {
+	path := t.TempDir()
+	repo, err := git.PlainInit(path, false)
+	if err != nil {
+		t.Fatalf("initialising repository: %v", err)
+	}
+
+	first := commitFile(t, repo, path, "app/main.go", "first")
+	docs := commitFile(t, repo, path, "README.md", "docs")
+	app := commitFile(t, repo, path, "app/main.go", "second")
+
+	f, err := NewGitPathFilter(path, []string{"app"}, nil, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if relevant, err := f.Relevant(context.Background(), first.String(), docs.String()); err != nil || relevant {
+		t.Errorf("expected documentation change not to be relevant, got %v (error %v)", relevant, err)
+	}
+
+	if relevant, err := f.Relevant(context.Background(), docs.String(), app.String()); err != nil || !relevant {
+		t.Errorf("expected application change to be relevant, got %v (error %v)", relevant, err)
+	}
+}
@@ -1,14 +1,46 @@
 package git
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
 	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
 )
 
-func GetLocalGitHeadHash(path string) (gitplumbing.Hash, error) {
+// CheckoutOptions control how much of a repository is obtained, and how its worktree
+// is populated.
+type CheckoutOptions struct {
+	// Depth is the number of commits of history to fetch from the tip of the branch.
+	// Zero means all history.
+	Depth int
+	// SparsePaths are the directories to check out. Empty means all directories.
+	SparsePaths []string
+	// Submodules causes submodules to be recursively cloned and updated.
+	Submodules bool
+	// LFS causes Git LFS pointer files to be replaced with the objects they point to.
+	LFS bool
+}
+
+// resetsOnUpdate returns whether the worktree must be reset to the fetched branch rather
+// than merged into.
+func (o CheckoutOptions) resetsOnUpdate() bool {
+	// A sparse checkout cannot be merged into, as the merge would check out every path.
+	// Resolved LFS objects appear as unstaged changes, which prevent a merge.
+	return len(o.SparsePaths) > 0 || o.LFS
+}
+
+func GetLocalGitHeadHash(ctx context.Context, logger *slog.Logger, path string) (gitplumbing.Hash, error) {
 	nilHash := gitplumbing.Hash{}
 
 	repo, err := git.PlainOpen(path)
@@ -23,11 +55,15 @@ func GetLocalGitHeadHash(path string) (gitplumbing.Hash, error) {
 
 	// Would prefer to use the short hash for brevity, but there does not seem to be support in go-git as yet.
 	// See https://github.com/src-d/go-git/issues/602
-	log.Printf("local hash is %q", head.Hash())
+	logger.InfoContext(ctx, "obtained local hash", "hash", head.Hash().String())
 	return head.Hash(), nil
 }
 
-func GetRemoteHeadHash(localPath, branch string) (gitplumbing.Hash, error) {
+func GetRemoteHeadHash(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	localPath string,
+	selector RefSelector) (gitplumbing.Hash, error) {
 	nilHash := gitplumbing.Hash{}
 
 	repo, err := git.PlainOpen(localPath)
@@ -40,48 +76,155 @@ func GetRemoteHeadHash(localPath, branch string) (gitplumbing.Hash, error) {
 		return nilHash, fmt.Errorf("obtaining remote on repository at %q: %w", localPath, err)
 	}
 
-	refs, err := remote.List(&git.ListOptions{})
+	ref, err := selectRemoteRef(ctx, remote, auth, selector)
 	if err != nil {
-		return nilHash, fmt.Errorf("listing remote refs for repository at %q: %w", localPath, err)
+		return nilHash, fmt.Errorf("selecting remote ref for repository at %q: %w", localPath, err)
 	}
 
-	// Get the HEAD ref of the target branch, see https://github.com/src-d/go-git/issues/767
-	targetRefName := "refs/heads/" + branch
-	for _, ref := range refs {
-		// Skip unwanted branch refs
-		if ref.Name().String() != targetRefName {
-			continue
-		}
+	logger.InfoContext(ctx, "obtained remote hash", "ref", ref.Name().String(), "hash", ref.Hash().String())
+	return ref.Hash(), nil
+}
 
-		// Target ref located
-		log.Printf("remote hash is %q", ref.Hash())
-		return ref.Hash(), nil
+// selectRemoteRef lists the refs of the remote, and returns the one selected. The hash of
+// the returned ref is that of the commit it points to.
+func selectRemoteRef(ctx context.Context,
+	remote *git.Remote,
+	auth transport.AuthMethod,
+	selector RefSelector) (*gitplumbing.Reference, error) {
+	refs, err := listRemoteRefs(ctx, remote, auth)
+	if err != nil {
+		return nil, err
 	}
 
-	// If we get here there was a failure to locate the target branch ref
-	return nilHash, fmt.Errorf("unable to locate target branch %q reference for repository at %q", branch, localPath)
+	return selector.Select(refs)
 }
 
-func CloneGitRepo(URL, branch, destPath string) error {
-	log.Printf("cloning branch %q of repository %q", branch, URL)
+// listRemoteRefs lists the refs of the remote. The hashes of the returned refs are those
+// of the commits they point to.
+func listRemoteRefs(ctx context.Context,
+	remote *git.Remote,
+	auth transport.AuthMethod) ([]*gitplumbing.Reference, error) {
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:          auth,
+		PeelingOption: git.AppendPeeled,
+	})
+	if err != nil {
+		var URL string
+		if URLs := remote.Config().URLs; len(URLs) > 0 {
+			URL = URLs[0]
+		}
+		return nil, fmt.Errorf("listing remote refs: %w", gitauth.RedactError(err, auth, URL))
+	}
 
-	// Options to clone just the master branch of the remote repo
+	return peel(refs), nil
+}
+
+func CloneGitRepo(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	URL string,
+	selector RefSelector,
+	destPath string,
+	checkout CheckoutOptions) error {
+	// The ref is selected before cloning, so that only it need be cloned
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{URL}})
+	ref, err := selectRemoteRef(ctx, remote, auth, selector)
+	if err != nil {
+		return fmt.Errorf("selecting ref of repository %q: %w", gitauth.RedactURL(URL), err)
+	}
+
+	// Only branches and tags can be cloned, so other refs, such as the heads of pull
+	// requests, are instead fetched into an empty repository
+	if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+		return initAndFetch(ctx, logger, auth, URL, selector, destPath, checkout)
+	}
+
+	logger.InfoContext(ctx, "cloning repository",
+		"ref", ref.Name().String(),
+		"url", gitauth.RedactURL(URL),
+		"depth", checkout.Depth,
+		"sparse_paths", checkout.SparsePaths,
+		"submodules", checkout.Submodules,
+		"lfs", checkout.LFS)
+
+	// Options to clone just the selected branch or tag of the remote repo
 	cloneOpts := &git.CloneOptions{
 		URL:           URL,
+		Auth:          auth,
 		SingleBranch:  true,
-		ReferenceName: gitplumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch)), // See https://github.com/src-d/go-git/issues/553
+		ReferenceName: ref.Name(), // See https://github.com/src-d/go-git/issues/553
+		Depth:         checkout.Depth,
+		// A sparse checkout is made after cloning
+		NoCheckout: len(checkout.SparsePaths) > 0,
 	}
 
 	// Clone to local directory
-	if _, err := git.PlainClone(destPath, false, cloneOpts); err != nil {
-		return fmt.Errorf("cloning branch %q of repository %q: %w", branch, URL, err)
+	repo, err := git.PlainCloneContext(ctx, destPath, false, cloneOpts)
+	if err != nil {
+		err = gitauth.RedactError(err, auth, URL)
+		return fmt.Errorf("cloning ref %q of repository %q: %w", ref.Name(), gitauth.RedactURL(URL), err)
 	}
 
-	return nil
+	if len(checkout.SparsePaths) > 0 {
+		worktree, err := repo.Worktree()
+		if err != nil {
+			return fmt.Errorf("getting worktree for Git repository at %q: %w", destPath, err)
+		}
+
+		// A branch remains checked out, whereas a tag is checked out as a detached HEAD
+		checkoutOpts := &git.CheckoutOptions{SparseCheckoutDirectories: checkout.SparsePaths}
+		if ref.Name().IsBranch() {
+			checkoutOpts.Branch = ref.Name()
+		} else {
+			checkoutOpts.Hash = ref.Hash()
+		}
+
+		if err := worktree.Checkout(checkoutOpts); err != nil {
+			return fmt.Errorf("checking out sparse paths of Git repository at %q: %w", destPath, err)
+		}
+	}
+
+	return completeCheckout(ctx, logger, auth, repo, destPath, checkout)
 }
 
-func PullGitRepo(path, branch string) error {
-	log.Printf("pulling branch %q of repository at %q", branch, path)
+// initAndFetch initialises an empty repository with the remote as its origin, and fetches
+// and checks out the selected ref.
+func initAndFetch(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	URL string,
+	selector RefSelector,
+	destPath string,
+	checkout CheckoutOptions) error {
+	logger.InfoContext(ctx, "initialising repository", "url", gitauth.RedactURL(URL), "path", destPath)
+
+	repo, err := git.PlainInit(destPath, false)
+	if err != nil {
+		return fmt.Errorf("initialising Git repository at %q: %w", destPath, err)
+	}
+
+	if _, err := repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{URL}}); err != nil {
+		return fmt.Errorf("creating remote on Git repository at %q: %w", destPath, err)
+	}
+
+	return FetchAndReset(ctx, logger, auth, destPath, selector, checkout)
+}
+
+func PullGitRepo(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	path string,
+	selector RefSelector,
+	checkout CheckoutOptions) error {
+	// The checkout never has local changes to merge, so can instead be reset.
+	// A tag, unlike a branch, is not merged into but replaced by another tag.
+	branchSelector, isBranch := selector.(*BranchSelector)
+	if checkout.resetsOnUpdate() || !isBranch {
+		return FetchAndReset(ctx, logger, auth, path, selector, checkout)
+	}
+	branch := branchSelector.Branch
+
+	logger.InfoContext(ctx, "pulling repository", "branch", branch, "path", path)
 
 	repo, err := git.PlainOpen(path)
 	if err != nil {
@@ -93,16 +236,395 @@ func PullGitRepo(path, branch string) error {
 		return fmt.Errorf("getting worktree for Git repository at %q: %w", path, err)
 	}
 
-	err = worktree.Pull(&git.PullOptions{
-		RemoteName: "origin",
-		SingleBranch: true,
+	err = worktree.PullContext(ctx, &git.PullOptions{
+		RemoteName:    "origin",
+		Auth:          auth,
+		SingleBranch:  true,
 		ReferenceName: gitplumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch)),
+		Depth:         checkout.Depth,
 	})
-	if err == git.NoErrAlreadyUpToDate {
-		return nil
-	} else if err != nil {
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		err = gitauth.RedactError(err, auth, originURL(repo))
 		return fmt.Errorf("pulling branch %q of Git repository at %q: %w", branch, path, err)
 	}
 
+	// Even if the branch is up-to-date, its submodules may not be
+	return completeCheckout(ctx, logger, auth, repo, path, checkout)
+}
+
+// completeCheckout populates the submodules and LFS objects of the worktree, if enabled.
+func completeCheckout(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	repo *git.Repository,
+	path string,
+	checkout CheckoutOptions) error {
+	if checkout.Submodules {
+		if err := updateSubmodules(ctx, logger, auth, repo, path, checkout.Depth); err != nil {
+			return err
+		}
+	}
+
+	if checkout.LFS {
+		if err := resolveLFSPointers(ctx, logger, auth, repo, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateSubmodules recursively initialises the submodules of the repository, and checks
+// out the commits recorded for them, using the same authentication as the repository.
+func updateSubmodules(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	repo *git.Repository,
+	path string,
+	depth int) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("getting worktree for Git repository at %q: %w", path, err)
+	}
+
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("reading submodules of Git repository at %q: %w", path, err)
+	}
+
+	if len(submodules) == 0 {
+		return nil
+	}
+
+	logger.InfoContext(ctx, "updating submodules", "path", path, "count", len(submodules))
+	if err := submodules.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+		Init:              true,
+		Auth:              auth,
+		Depth:             depth,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+	}); err != nil {
+		err = gitauth.RedactError(err, auth, originURL(repo))
+		return fmt.Errorf("updating submodules of Git repository at %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// SubmodulesInSync returns whether every submodule of the repository at the path has
+// checked out the commit recorded for it in the HEAD commit of the repository.
+func SubmodulesInSync(ctx context.Context, logger *slog.Logger, path string) (bool, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return false, fmt.Errorf("opening Git repository at %q: %w", path, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("getting worktree for Git repository at %q: %w", path, err)
+	}
+
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return false, fmt.Errorf("reading submodules of Git repository at %q: %w", path, err)
+	}
+
+	statuses, err := submodules.Status()
+	if err != nil {
+		return false, fmt.Errorf("getting status of submodules of Git repository at %q: %w", path, err)
+	}
+
+	for _, status := range statuses {
+		if !status.IsClean() {
+			logger.InfoContext(ctx, "submodule out of sync",
+				"submodule", status.Path,
+				"hash", status.Current.String(),
+				"expected_hash", status.Expected.String())
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// originURL returns the URL of the origin remote of the repository, or an empty string if
+// it cannot be determined.
+func originURL(repo *git.Repository) string {
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+
+	return remote.Config().URLs[0]
+}
+
+// IsAncestor returns whether the ancestor commit is an ancestor of, or the same as, the
+// descendant commit in the repository at the path.
+func IsAncestor(ctx context.Context, path, ancestor, descendant string) (bool, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return false, fmt.Errorf("opening Git repository at %q: %w", path, err)
+	}
+
+	ancestorCommit, err := repo.CommitObject(gitplumbing.NewHash(ancestor))
+	if err != nil {
+		return false, fmt.Errorf("reading commit %q of Git repository at %q: %w", ancestor, path, err)
+	}
+
+	descendantCommit, err := repo.CommitObject(gitplumbing.NewHash(descendant))
+	if err != nil {
+		return false, fmt.Errorf("reading commit %q of Git repository at %q: %w", descendant, path, err)
+	}
+
+	// In a shallow clone, the walk fails if the history ends before the ancestor is found.
+	// The walk may cover the whole history, so it is stopped upon cancellation.
+	isAncestor := false
+	err = object.NewCommitPreorderIter(descendantCommit, nil, nil).ForEach(func(commit *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if commit.Hash == ancestorCommit.Hash {
+			isAncestor = true
+			return storer.ErrStop
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("walking history of Git repository at %q: %w", path, err)
+	}
+
+	return isAncestor, nil
+}
+
+// ChangedPaths returns the paths of the files which differ between the trees of the two
+// commits, whether added, modified or deleted. A renamed file has both its old and new
+// paths returned.
+func ChangedPaths(ctx context.Context, path, from, to string) ([]string, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening Git repository at %q: %w", path, err)
+	}
+
+	trees := make([]*object.Tree, 0, 2)
+	for _, hash := range []string{from, to} {
+		commit, err := repo.CommitObject(gitplumbing.NewHash(hash))
+		if err != nil {
+			return nil, fmt.Errorf("reading commit %q of Git repository at %q: %w", hash, path, err)
+		}
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("reading tree of commit %q of Git repository at %q: %w", hash, path, err)
+		}
+		trees = append(trees, tree)
+	}
+
+	changes, err := object.DiffTreeWithOptions(ctx, trees[0], trees[1], object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("diffing commits %q and %q of Git repository at %q: %w", from, to, path, err)
+	}
+
+	var paths []string
+	for _, change := range changes {
+		if change.From.Name != "" {
+			paths = append(paths, change.From.Name)
+		}
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			paths = append(paths, change.To.Name)
+		}
+	}
+
+	return paths, nil
+}
+
+// VerifyGitRepo checks that the repository at the path is a clone of the URL, and that
+// the objects of its HEAD commit are intact.
+func VerifyGitRepo(ctx context.Context, logger *slog.Logger, path, URL string) error {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return fmt.Errorf("opening Git repository at %q: %w", path, err)
+	}
+
+	if origin := originURL(repo); normaliseURL(origin) != normaliseURL(URL) {
+		return fmt.Errorf("origin of Git repository at %q is %q, not %q",
+			path, gitauth.RedactURL(origin), gitauth.RedactURL(URL))
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("obtaining Git HEAD reference on repository at %q: %w", path, err)
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("reading HEAD commit of Git repository at %q: %w", path, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("reading HEAD tree of Git repository at %q: %w", path, err)
+	}
+
+	// Walking the files reads every tree and blob object, so any which are missing or
+	// corrupt are detected
+	if err := tree.Files().ForEach(func(*object.File) error { return ctx.Err() }); err != nil {
+		return fmt.Errorf("reading HEAD files of Git repository at %q: %w", path, err)
+	}
+
+	logger.InfoContext(ctx, "verified existing repository", "path", path, "hash", head.Hash().String())
 	return nil
 }
+
+// FetchAndReset fetches the selected ref from the origin remote, then checks it out and
+// hard-resets the worktree to it, removing any untracked files. A branch is checked out
+// as the local branch, whereas a tag is checked out as a detached HEAD.
+func FetchAndReset(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	path string,
+	selector RefSelector,
+	checkout CheckoutOptions) error {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return fmt.Errorf("opening Git repository at %q: %w", path, err)
+	}
+
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return fmt.Errorf("obtaining remote on repository at %q: %w", path, err)
+	}
+
+	ref, err := selectRemoteRef(ctx, remote, auth, selector)
+	if err != nil {
+		return fmt.Errorf("selecting remote ref for repository at %q: %w", path, err)
+	}
+
+	logger.InfoContext(ctx, "fetching repository", "ref", ref.Name().String(), "path", path)
+
+	// A branch is fetched to its remote-tracking ref, whereas a tag or other ref is fetched
+	// as itself
+	fetchedRefName := ref.Name()
+	if ref.Name().IsBranch() {
+		fetchedRefName = gitplumbing.NewRemoteReferenceName("origin", ref.Name().Short())
+	}
+
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       auth,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+%s:%s", ref.Name(), fetchedRefName))},
+		Force:      true,
+		Depth:      checkout.Depth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		err = gitauth.RedactError(err, auth, originURL(repo))
+		return fmt.Errorf("fetching ref %q of Git repository at %q: %w", ref.Name(), path, err)
+	}
+
+	fetchedRef, err := repo.Reference(fetchedRefName, true)
+	if err != nil {
+		return fmt.Errorf("resolving fetched ref %q of Git repository at %q: %w", ref.Name(), path, err)
+	}
+
+	hash, err := peelToCommit(repo, fetchedRef.Hash())
+	if err != nil {
+		return fmt.Errorf("resolving commit of ref %q of Git repository at %q: %w", ref.Name(), path, err)
+	}
+
+	head := gitplumbing.NewHashReference(gitplumbing.HEAD, hash)
+	if ref.Name().IsBranch() {
+		if err := repo.Storer.SetReference(gitplumbing.NewHashReference(ref.Name(), hash)); err != nil {
+			return fmt.Errorf("updating branch %q of Git repository at %q: %w", ref.Name().Short(), path, err)
+		}
+
+		head = gitplumbing.NewSymbolicReference(gitplumbing.HEAD, ref.Name())
+	}
+
+	if err := repo.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("checking out ref %q of Git repository at %q: %w", ref.Name(), path, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("getting worktree for Git repository at %q: %w", path, err)
+	}
+
+	resetOpts := &git.ResetOptions{Commit: hash, Mode: git.HardReset}
+	if len(checkout.SparsePaths) > 0 {
+		err = worktree.ResetSparsely(resetOpts, checkout.SparsePaths)
+	} else {
+		err = worktree.Reset(resetOpts)
+	}
+	if err != nil {
+		return fmt.Errorf("resetting Git repository at %q: %w", path, err)
+	}
+
+	if err := worktree.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return fmt.Errorf("cleaning Git repository at %q: %w", path, err)
+	}
+
+	logger.InfoContext(ctx, "reset repository", "ref", ref.Name().String(), "hash", hash.String())
+	return completeCheckout(ctx, logger, auth, repo, path, checkout)
+}
+
+// peelToCommit returns the hash of the commit pointed to by the object, which is either
+// a commit or an annotated tag.
+func peelToCommit(repo *git.Repository, hash gitplumbing.Hash) (gitplumbing.Hash, error) {
+	tag, err := repo.TagObject(hash)
+	if errors.Is(err, gitplumbing.ErrObjectNotFound) {
+		return hash, nil
+	} else if err != nil {
+		return gitplumbing.ZeroHash, err
+	}
+
+	commit, err := tag.Commit()
+	if err != nil {
+		return gitplumbing.ZeroHash, err
+	}
+
+	return commit.Hash, nil
+}
+
+// HeadTags returns the tags which point to the HEAD commit of the repository at the path.
+// The hashes of the returned tags are that of the commit, i.e. annotated tags are peeled.
+func HeadTags(path string) ([]*gitplumbing.Reference, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening Git repository at %q: %w", path, err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("obtaining Git HEAD reference on repository at %q: %w", path, err)
+	}
+
+	tags, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("listing tags of Git repository at %q: %w", path, err)
+	}
+
+	var headTags []*gitplumbing.Reference
+	err = tags.ForEach(func(tag *gitplumbing.Reference) error {
+		hash, err := peelToCommit(repo, tag.Hash())
+		if err != nil {
+			return fmt.Errorf("resolving commit of tag %q: %w", tag.Name().Short(), err)
+		}
+
+		if hash == head.Hash() {
+			headTags = append(headTags, gitplumbing.NewHashReference(tag.Name(), hash))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing tags of Git repository at %q: %w", path, err)
+	}
+
+	return headTags, nil
+}
+
+// normaliseURL removes the differences between URLs which do not change the repository
+// they refer to.
+func normaliseURL(URL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(URL, "/"), ".git")
+}
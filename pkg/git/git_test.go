@@ -0,0 +1,43 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestIsAncestor(t *testing.T) {
+	repo, path := commitFiles(t, "https://git.example.com/org/repo.git", map[string]string{"README": "readme"})
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	commit := head.Hash().String()
+
+	isAncestor, err := IsAncestor(context.Background(), path, commit, commit)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !isAncestor {
+		t.Error("expected commit to be an ancestor of itself")
+	}
+}
+
+func TestIsAncestorStopsUponCancellation(t *testing.T) {
+	repo, path := commitFiles(t, "https://git.example.com/org/repo.git", map[string]string{"README": "readme"})
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	commit := head.Hash().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := IsAncestor(ctx, path, commit, commit); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context cancelled error, got %v", err)
+	}
+}
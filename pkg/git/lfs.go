@@ -0,0 +1,598 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+)
+
+// See https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md
+// and https://github.com/git-lfs/git-lfs/blob/main/docs/api/batch.md
+const (
+	lfsPointerVersion  = "version https://git-lfs.github.com/spec/v1"
+	lfsPointerMaxSize  = 1024
+	lfsMediaType       = "application/vnd.git-lfs+json"
+	lfsBatchSize       = 100
+	lfsTransferBasic   = "basic"
+	lfsOperationGet    = "download"
+	lfsOIDPrefixSHA256 = "sha256:"
+	// lfsSSHAuthCommand is run on the host of an SSH remote to obtain the credentials
+	// of its LFS server
+	lfsSSHAuthCommand = "git-lfs-authenticate"
+	defaultSSHPort    = 22
+)
+
+// lfsClient is the HTTP client used to communicate with the LFS server.
+var lfsClient = http.DefaultClient
+
+type lfsPointer struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type lfsBatchRequest struct {
+	Operation string       `json:"operation"`
+	Transfers []string     `json:"transfers"`
+	Objects   []lfsPointer `json:"objects"`
+}
+
+type lfsBatchResponse struct {
+	Objects []struct {
+		lfsPointer
+		Actions struct {
+			Download *struct {
+				Href   string            `json:"href"`
+				Header map[string]string `json:"header"`
+			} `json:"download"`
+		} `json:"actions"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"objects"`
+}
+
+// lfsSSHAuthResponse is the output of git-lfs-authenticate.
+type lfsSSHAuthResponse struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// lfsHeaderAuth is the auth method of the headers granted by git-lfs-authenticate.
+type lfsHeaderAuth struct {
+	Header map[string]string
+}
+
+func (a *lfsHeaderAuth) Name() string {
+	return "lfs-header-auth"
+}
+
+func (a *lfsHeaderAuth) String() string {
+	// The headers are credentials, so are not included
+	return a.Name()
+}
+
+// parseLFSPointer returns the pointer contained in the file contents, and whether the
+// contents are a pointer.
+func parseLFSPointer(contents []byte) (lfsPointer, bool) {
+	pointer := lfsPointer{Size: -1}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	if !scanner.Scan() || scanner.Text() != lfsPointerVersion {
+		return pointer, false
+	}
+
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			return pointer, false
+		}
+
+		switch key {
+		case "oid":
+			oid, found := strings.CutPrefix(value, lfsOIDPrefixSHA256)
+			if _, err := hex.DecodeString(oid); !found || err != nil || len(oid) != sha256.Size*2 {
+				return pointer, false
+			}
+			pointer.OID = oid
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return pointer, false
+			}
+			pointer.Size = size
+		}
+	}
+
+	return pointer, pointer.OID != "" && pointer.Size >= 0
+}
+
+// lfsEndpoint returns the URL of the LFS server for the Git remote URL, and any
+// credentials embedded within it. LFS objects of SSH remotes are fetched over HTTPS, from
+// this URL unless git-lfs-authenticate returns another.
+func lfsEndpoint(remoteURL string) (string, *githttp.BasicAuth, error) {
+	endpoint, err := transport.NewEndpoint(remoteURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing remote URL: %w", gitauth.RedactError(err, nil, remoteURL))
+	}
+
+	lfsURL := &url.URL{Scheme: endpoint.Protocol, Host: endpoint.Host, Path: endpoint.Path}
+	switch endpoint.Protocol {
+	case "http", "https":
+	case "ssh":
+		lfsURL.Scheme = "https"
+	default:
+		return "", nil, fmt.Errorf("LFS is not supported for %q remotes", endpoint.Protocol)
+	}
+
+	if endpoint.Port != 0 && endpoint.Protocol != "ssh" {
+		lfsURL.Host = fmt.Sprintf("%s:%d", endpoint.Host, endpoint.Port)
+	}
+
+	if !strings.HasPrefix(lfsURL.Path, "/") {
+		lfsURL.Path = "/" + lfsURL.Path
+	}
+	lfsURL.Path = strings.TrimSuffix(lfsURL.Path, "/")
+	if !strings.HasSuffix(lfsURL.Path, ".git") {
+		lfsURL.Path += ".git"
+	}
+	lfsURL.Path += "/info/lfs"
+
+	var urlAuth *githttp.BasicAuth
+	if endpoint.Protocol != "ssh" && endpoint.Password != "" {
+		urlAuth = &githttp.BasicAuth{Username: endpoint.User, Password: endpoint.Password}
+	}
+
+	return lfsURL.String(), urlAuth, nil
+}
+
+// authenticate adds the credentials of the auth method to the request.
+func authenticate(req *http.Request, auth transport.AuthMethod) {
+	switch auth := auth.(type) {
+	case *githttp.BasicAuth:
+		req.SetBasicAuth(auth.Username, auth.Password)
+	case *githttp.TokenAuth:
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+	case *lfsHeaderAuth:
+		for name, value := range auth.Header {
+			req.Header.Set(name, value)
+		}
+	}
+}
+
+// sshLFSAuthenticate runs git-lfs-authenticate on the host of the SSH remote, which
+// returns the URL of its LFS server, if not the default, and the headers granting access.
+func sshLFSAuthenticate(ctx context.Context, remoteURL string, auth gitssh.AuthMethod) (*lfsSSHAuthResponse, error) {
+	endpoint, err := transport.NewEndpoint(remoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing remote URL: %w", gitauth.RedactError(err, nil, remoteURL))
+	}
+
+	config, err := auth.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("configuring SSH client: %w", err)
+	}
+
+	port := endpoint.Port
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	addr := net.JoinHostPort(endpoint.Host, strconv.Itoa(port))
+
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %q: %w", addr, err)
+	}
+	defer conn.Close()
+
+	// Closing the connection upon cancellation interrupts the handshake and the command
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("establishing SSH connection to %q: %w", addr, contextError(ctx, err))
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("opening SSH session to %q: %w", addr, contextError(ctx, err))
+	}
+	defer session.Close()
+
+	output, err := session.Output(lfsSSHAuthCommand + " " + endpoint.Path + " " + lfsOperationGet)
+	if err != nil {
+		return nil, fmt.Errorf("running %s on %q: %w", lfsSSHAuthCommand, addr, contextError(ctx, err))
+	}
+
+	resp := new(lfsSSHAuthResponse)
+	if err := json.Unmarshal(output, resp); err != nil {
+		return nil, fmt.Errorf("decoding %s output: %w", lfsSSHAuthCommand, err)
+	}
+
+	return resp, nil
+}
+
+// contextError returns the error of the context if it is done, as that is the cause of
+// the error of a connection closed upon cancellation.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// resolveLFSPointers replaces the LFS pointer files checked out in the worktree, and in
+// those of its checked out submodules, with the objects they point to. Objects are taken
+// from the local cache of the repository, or else downloaded from the LFS server of the
+// origin remote of the repository or submodule and cached.
+func resolveLFSPointers(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	repo *git.Repository,
+	path string) error {
+	// Objects are cached where git-lfs would, so are shared by the submodules
+	cacheDir := filepath.Join(path, git.GitDirName, "lfs", "objects")
+
+	return resolveLFSPointersRecursively(ctx, logger, auth, repo, path, cacheDir)
+}
+
+func resolveLFSPointersRecursively(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	repo *git.Repository,
+	path string,
+	cacheDir string) error {
+	if err := resolveWorktreeLFSPointers(ctx, logger, auth, repo, path, cacheDir); err != nil {
+		return err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("getting worktree for Git repository at %q: %w", path, err)
+	}
+
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("reading submodules of Git repository at %q: %w", path, err)
+	}
+
+	for _, submodule := range submodules {
+		subPath := filepath.Join(path, filepath.FromSlash(submodule.Config().Path))
+
+		subRepo, err := submodule.Repository()
+		if errors.Is(err, git.ErrSubmoduleNotInitialized) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("opening submodule of Git repository at %q: %w", subPath, err)
+		}
+
+		// A submodule which is initialised but not checked out has no pointers
+		if _, err := subRepo.Head(); errors.Is(err, gitplumbing.ErrReferenceNotFound) {
+			continue
+		}
+
+		if err := resolveLFSPointersRecursively(ctx, logger, auth, subRepo, subPath, cacheDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveWorktreeLFSPointers replaces the LFS pointer files checked out in the worktree of
+// the repository alone.
+func resolveWorktreeLFSPointers(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	repo *git.Repository,
+	path string,
+	cacheDir string) error {
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("obtaining Git HEAD reference on repository at %q: %w", path, err)
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("reading HEAD commit of Git repository at %q: %w", path, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("reading HEAD tree of Git repository at %q: %w", path, err)
+	}
+
+	// Pointers are found from the committed blobs, rather than the worktree, to avoid
+	// reading large files. Paths which are not checked out (e.g. due to a sparse checkout)
+	// are skipped. Submodules are not descended into by the tree.
+	pointerPaths := make(map[lfsPointer][]string)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if file.Size > lfsPointerMaxSize || !file.Mode.IsFile() {
+			return nil
+		}
+
+		contents, err := file.Contents()
+		if err != nil {
+			return err
+		}
+
+		pointer, ok := parseLFSPointer([]byte(contents))
+		if !ok {
+			return nil
+		}
+
+		filePath := filepath.Join(path, filepath.FromSlash(file.Name))
+		if _, err := os.Stat(filePath); err != nil {
+			return nil
+		}
+
+		pointerPaths[pointer] = append(pointerPaths[pointer], filePath)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("finding LFS pointers in Git repository at %q: %w", path, err)
+	}
+
+	for pointer, paths := range pointerPaths {
+		objectPath := lfsObjectPath(cacheDir, pointer)
+		if info, err := os.Stat(objectPath); err != nil || info.Size() != pointer.Size {
+			continue
+		}
+
+		if err := placeLFSObject(objectPath, paths); err != nil {
+			return fmt.Errorf("replacing LFS pointers in Git repository at %q with cached object %q: %w",
+				path, pointer.OID, err)
+		}
+		delete(pointerPaths, pointer)
+	}
+
+	if len(pointerPaths) == 0 {
+		return nil
+	}
+
+	remoteURL := originURL(repo)
+	endpoint, urlAuth, err := lfsEndpoint(remoteURL)
+	if err != nil {
+		return fmt.Errorf("determining LFS server of Git repository at %q: %w", path, err)
+	}
+
+	// Credentials embedded within the remote URL are used if no others are configured
+	if auth == nil && urlAuth != nil {
+		auth = urlAuth
+	}
+
+	// The credentials of SSH remotes can not be used over HTTPS, so the host is asked
+	// for those of its LFS server
+	if sshAuth, ok := auth.(gitssh.AuthMethod); ok {
+		sshResp, err := sshLFSAuthenticate(ctx, remoteURL, sshAuth)
+		if err != nil {
+			return fmt.Errorf("authenticating to LFS server of Git repository at %q: %w", path, err)
+		}
+
+		if sshResp.Href != "" {
+			endpoint = strings.TrimSuffix(sshResp.Href, "/")
+		}
+		auth = &lfsHeaderAuth{Header: sshResp.Header}
+	}
+
+	logger.InfoContext(ctx, "resolving LFS pointers",
+		"path", path,
+		"objects", len(pointerPaths),
+		"endpoint", gitauth.RedactURL(endpoint))
+
+	pointers := make([]lfsPointer, 0, len(pointerPaths))
+	for pointer := range pointerPaths {
+		pointers = append(pointers, pointer)
+	}
+
+	for start := 0; start < len(pointers); start += lfsBatchSize {
+		batch := pointers[start:min(start+lfsBatchSize, len(pointers))]
+		if err := downloadLFSBatch(ctx, auth, endpoint, batch, pointerPaths, cacheDir); err != nil {
+			err = gitauth.RedactError(err, auth, remoteURL)
+			return fmt.Errorf("downloading LFS objects of Git repository at %q: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// downloadLFSBatch requests the download actions for the batch of objects from the LFS
+// server, then downloads each into the cache and to the paths of its pointer files.
+func downloadLFSBatch(ctx context.Context,
+	auth transport.AuthMethod,
+	endpoint string,
+	batch []lfsPointer,
+	pointerPaths map[lfsPointer][]string,
+	cacheDir string) error {
+	body, err := json.Marshal(lfsBatchRequest{
+		Operation: lfsOperationGet,
+		Transfers: []string{lfsTransferBasic},
+		Objects:   batch,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding batch request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating batch request: %w", err)
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	authenticate(req, auth)
+
+	resp, err := lfsClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending batch request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("batch request failed with status %q", resp.Status)
+	}
+
+	batchResp := new(lfsBatchResponse)
+	if err := json.NewDecoder(resp.Body).Decode(batchResp); err != nil {
+		return fmt.Errorf("decoding batch response: %w", err)
+	}
+
+	for _, obj := range batchResp.Objects {
+		if obj.Error != nil {
+			return fmt.Errorf("object %q: %s (code %d)", obj.OID, obj.Error.Message, obj.Error.Code)
+		}
+
+		if obj.Actions.Download == nil {
+			return fmt.Errorf("object %q: no download action", obj.OID)
+		}
+
+		paths, ok := pointerPaths[obj.lfsPointer]
+		if !ok {
+			return fmt.Errorf("object %q: not requested", obj.OID)
+		}
+
+		download := obj.Actions.Download
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, download.Href, nil)
+		if err != nil {
+			return fmt.Errorf("object %q: creating download request: %w", obj.OID, err)
+		}
+
+		for name, value := range download.Header {
+			req.Header.Set(name, value)
+		}
+
+		// The credentials are only sent to the LFS server itself, not to any storage
+		// service it redirects to, which is authorised by the action's headers
+		if req.Header.Get("Authorization") == "" && sameHost(endpoint, download.Href) {
+			authenticate(req, auth)
+		}
+
+		if err := downloadLFSObject(req, obj.lfsPointer, paths, cacheDir); err != nil {
+			return fmt.Errorf("object %q: %w", obj.OID, err)
+		}
+	}
+
+	return nil
+}
+
+// lfsObjectPath returns the path of the object in the cache, laid out as by git-lfs.
+func lfsObjectPath(cacheDir string, pointer lfsPointer) string {
+	return filepath.Join(cacheDir, pointer.OID[0:2], pointer.OID[2:4], pointer.OID)
+}
+
+// downloadLFSObject downloads the object and verifies it against the pointer, before
+// caching it and replacing the pointer files with it.
+func downloadLFSObject(req *http.Request, pointer lfsPointer, paths []string, cacheDir string) error {
+	resp, err := lfsClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed with status %q", resp.Status)
+	}
+
+	// Download alongside the cached object, so that it can be renamed into place
+	objectPath := lfsObjectPath(cacheDir, pointer)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(objectPath), ".lfs-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), resp.Body)
+	if err != nil {
+		return fmt.Errorf("downloading: %w", err)
+	}
+
+	if size != pointer.Size || hex.EncodeToString(hash.Sum(nil)) != pointer.OID {
+		return fmt.Errorf("downloaded object does not match pointer (size %d)", size)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), objectPath); err != nil {
+		return fmt.Errorf("caching: %w", err)
+	}
+
+	return placeLFSObject(objectPath, paths)
+}
+
+// placeLFSObject replaces the pointer files with copies of the object, keeping their modes.
+func placeLFSObject(objectPath string, paths []string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("reading pointer file %q: %w", path, err)
+		}
+
+		if err := copyFile(objectPath, path, info.Mode()); err != nil {
+			return fmt.Errorf("replacing pointer file %q: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(srcPath, destPath string, mode os.FileMode) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dest, src); err != nil {
+		dest.Close()
+		return err
+	}
+
+	return dest.Close()
+}
+
+func sameHost(URL1, URL2 string) bool {
+	parsed1, err1 := url.Parse(URL1)
+	parsed2, err2 := url.Parse(URL2)
+
+	return err1 == nil && err2 == nil && parsed1.Host == parsed2.Host
+}
@@ -0,0 +1,405 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func pointerFor(contents string) (lfsPointer, string) {
+	sum := sha256.Sum256([]byte(contents))
+	pointer := lfsPointer{OID: hex.EncodeToString(sum[:]), Size: int64(len(contents))}
+
+	return pointer, fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, pointer.OID, pointer.Size)
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	expected, contents := pointerFor("large object")
+
+	pointer, ok := parseLFSPointer([]byte(contents))
+	if !ok {
+		t.Fatal("expected pointer to be parsed")
+	}
+
+	if pointer != expected {
+		t.Errorf("expected %+v, got %+v", expected, pointer)
+	}
+
+	for _, contents := range []string{
+		"not a pointer",
+		lfsPointerVersion + "\noid sha256:abc\nsize 1\n",
+		lfsPointerVersion + "\noid sha256:" + expected.OID + "\n",
+	} {
+		if _, ok := parseLFSPointer([]byte(contents)); ok {
+			t.Errorf("expected %q not to be parsed as a pointer", contents)
+		}
+	}
+}
+
+func TestLFSEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"https://git.example.com/org/repo.git":        "https://git.example.com/org/repo.git/info/lfs",
+		"https://git.example.com:8443/org/repo":       "https://git.example.com:8443/org/repo.git/info/lfs",
+		"git@git.example.com:org/repo.git":            "https://git.example.com/org/repo.git/info/lfs",
+		"ssh://git@git.example.com:2222/org/repo.git": "https://git.example.com/org/repo.git/info/lfs",
+	}
+
+	for remoteURL, expected := range cases {
+		endpoint, _, err := lfsEndpoint(remoteURL)
+		if err != nil {
+			t.Errorf("expected nil error for %q, got %v", remoteURL, err)
+			continue
+		}
+
+		if endpoint != expected {
+			t.Errorf("expected %q for %q, got %q", expected, remoteURL, endpoint)
+		}
+	}
+}
+
+// lfsServer serves the object from the LFS server of the repository at the path, to
+// clients authenticated with the credentials, and counts the batch requests it receives.
+func lfsServer(t *testing.T, auth *githttp.BasicAuth, repoPath, lfsObject string) (string, *atomic.Int32) {
+	t.Helper()
+
+	pointer, _ := pointerFor(lfsObject)
+	batches := new(atomic.Int32)
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("POST "+repoPath+"/info/lfs/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		batches.Add(1)
+		if username, password, _ := r.BasicAuth(); username != auth.Username || password != auth.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		req := new(lfsBatchRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || len(req.Objects) != 1 || req.Objects[0] != pointer {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", lfsMediaType)
+		fmt.Fprintf(w, `{"objects":[{"oid":%q,"size":%d,"actions":{"download":{"href":%q}}}]}`,
+			pointer.OID, pointer.Size, server.URL+"/objects/"+pointer.OID)
+	})
+	mux.HandleFunc("GET /objects/{oid}", func(w http.ResponseWriter, r *http.Request) {
+		if username, _, _ := r.BasicAuth(); username != auth.Username || r.PathValue("oid") != pointer.OID {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		io.WriteString(w, lfsObject)
+	})
+
+	return server.URL + repoPath, batches
+}
+
+func TestResolveLFSPointers(t *testing.T) {
+	const lfsObject = "large binary object"
+	_, pointerContents := pointerFor(lfsObject)
+
+	auth := &githttp.BasicAuth{Username: "deploy", Password: "token"}
+	remoteURL, batches := lfsServer(t, auth, "/org/repo.git", lfsObject)
+
+	repo, path := commitFiles(t, remoteURL,
+		map[string]string{"image.png": pointerContents, "README": "not a pointer"})
+
+	if err := resolveLFSPointers(context.Background(), discardLogger, auth, repo, path); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	for name, expected := range map[string]string{"image.png": lfsObject, "README": "not a pointer"} {
+		contents, err := os.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			t.Fatalf("reading file: %v", err)
+		}
+
+		if string(contents) != expected {
+			t.Errorf("expected %q to contain %q, got %q", name, expected, string(contents))
+		}
+	}
+
+	// Once the pointer is checked out again, the object is taken from the cache
+	if err := os.WriteFile(filepath.Join(path, "image.png"), []byte(pointerContents), 0o644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	if err := resolveLFSPointers(context.Background(), discardLogger, auth, repo, path); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(path, "image.png"))
+	if err != nil {
+		t.Fatalf("reading file: %v", err)
+	}
+
+	if string(contents) != lfsObject {
+		t.Errorf("expected cached object %q, got %q", lfsObject, string(contents))
+	}
+
+	if count := batches.Load(); count != 1 {
+		t.Errorf("expected 1 batch request, got %d", count)
+	}
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-c", "protocol.file.allow=always"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("running git %v: %v: %s", args, err, output)
+	}
+}
+
+func TestResolveLFSPointersInSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git CLI not available")
+	}
+
+	const lfsObject = "large binary object of submodule"
+	_, pointerContents := pointerFor(lfsObject)
+
+	auth := &githttp.BasicAuth{Username: "deploy", Password: "token"}
+	subURL, _ := lfsServer(t, auth, "/org/assets.git", lfsObject)
+
+	_, subPath := commitFiles(t, subURL, map[string]string{"image.png": pointerContents})
+
+	remotePath := t.TempDir()
+	runGit(t, remotePath, "init", "-b", "main")
+	runGit(t, remotePath, "submodule", "add", subPath, "assets")
+	runGit(t, remotePath, "commit", "-m", "add submodule")
+
+	// The submodule's objects are fetched from the LFS server of its own origin
+	destPath := filepath.Join(t.TempDir(), "src")
+	runGit(t, filepath.Dir(destPath), "clone", "--recurse-submodules", remotePath, destPath)
+	runGit(t, filepath.Join(destPath, "assets"), "remote", "set-url", "origin", subURL)
+
+	repo, err := git.PlainOpen(destPath)
+	if err != nil {
+		t.Fatalf("opening repository: %v", err)
+	}
+
+	if err := resolveLFSPointers(context.Background(), discardLogger, auth, repo, destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(destPath, "assets", "image.png"))
+	if err != nil {
+		t.Fatalf("reading file: %v", err)
+	}
+
+	if string(contents) != lfsObject {
+		t.Errorf("expected %q, got %q", lfsObject, string(contents))
+	}
+}
+
+// commitFiles commits the files to a new repository, whose origin remote has the URL.
+func commitFiles(t *testing.T, remoteURL string, files map[string]string) (*git.Repository, string) {
+	t.Helper()
+
+	path := t.TempDir()
+	repo, err := git.PlainInit(path, false)
+	if err != nil {
+		t.Fatalf("initialising repository: %v", err)
+	}
+
+	if _, err := repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{remoteURL},
+	}); err != nil {
+		t.Fatalf("creating remote: %v", err)
+	}
+
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(path, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("writing file: %v", err)
+		}
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("getting worktree: %v", err)
+	}
+
+	if err := worktree.AddGlob("*"); err != nil {
+		t.Fatalf("adding files: %v", err)
+	}
+
+	if _, err := worktree.Commit("add files", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	}); err != nil {
+		t.Fatalf("committing: %v", err)
+	}
+
+	return repo, path
+}
+
+// sshServer serves SSH connections authenticated by the client key, replying to the
+// command run with the output, and sending each command run on the returned channel.
+func sshServer(t *testing.T, clientKey ssh.PublicKey, output string) (string, ssh.PublicKey, <-chan string) {
+	t.Helper()
+
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating host key: %v", err)
+	}
+
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivateKey)
+	if err != nil {
+		t.Fatalf("creating host key signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, fmt.Errorf("unknown key for %q", conn.User())
+			}
+
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	commands := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+
+			for req := range requests {
+				var exec struct{ Command string }
+				if req.Type != "exec" || ssh.Unmarshal(req.Payload, &exec) != nil {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				commands <- exec.Command
+
+				io.WriteString(channel, output)
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+			}
+		}
+	}()
+
+	return listener.Addr().String(), hostSigner.PublicKey(), commands
+}
+
+func TestResolveLFSPointersOfSSHRemote(t *testing.T) {
+	const lfsObject = "large binary object"
+	pointer, pointerContents := pointerFor(lfsObject)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("POST /lfs/objects/batch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "RemoteAuth granted" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", lfsMediaType)
+		fmt.Fprintf(w, `{"objects":[{"oid":%q,"size":%d,"actions":{"download":{"href":%q}}}]}`,
+			pointer.OID, pointer.Size, server.URL+"/objects/"+pointer.OID)
+	})
+	mux.HandleFunc("GET /objects/{oid}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "RemoteAuth granted" || r.PathValue("oid") != pointer.OID {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		io.WriteString(w, lfsObject)
+	})
+
+	_, clientPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating client key: %v", err)
+	}
+
+	clientSigner, err := ssh.NewSignerFromKey(clientPrivateKey)
+	if err != nil {
+		t.Fatalf("creating client key signer: %v", err)
+	}
+
+	addr, hostKey, commands := sshServer(t, clientSigner.PublicKey(),
+		fmt.Sprintf(`{"href":%q,"header":{"Authorization":"RemoteAuth granted"}}`, server.URL+"/lfs/"))
+	auth := &gitssh.PublicKeys{
+		User:   "git",
+		Signer: clientSigner,
+		HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+		},
+	}
+
+	repo, path := commitFiles(t, "ssh://git@"+addr+"/org/repo.git", map[string]string{"image.png": pointerContents})
+
+	if err := resolveLFSPointers(context.Background(), discardLogger, auth, repo, path); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if command := <-commands; command != "git-lfs-authenticate /org/repo.git download" {
+		t.Errorf("expected git-lfs-authenticate to be run for download, got %q", command)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(path, "image.png"))
+	if err != nil {
+		t.Fatalf("reading file: %v", err)
+	}
+
+	if string(contents) != lfsObject {
+		t.Errorf("expected %q to contain %q, got %q", "image.png", lfsObject, string(contents))
+	}
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+)
+
+// pullRequestRef matches the refs under which hosts expose the head commits of pull
+// requests, i.e. "refs/pull/<n>/head" on GitHub and Gitea, and
+// "refs/merge-requests/<n>/head" on GitLab.
+var pullRequestRef = regexp.MustCompile(`^refs/(?:pull|merge-requests)/([1-9][0-9]*)/head$`)
+
+// pullRequestMergeRef matches the refs under which hosts expose the result of merging
+// pull requests into their base, i.e. "refs/pull/<n>/merge" on GitHub and
+// "refs/merge-requests/<n>/merge" on GitLab. Unlike the heads of pull requests, which are
+// kept forever, these are removed once a pull request is closed or merged.
+var pullRequestMergeRef = regexp.MustCompile(`^refs/(?:pull|merge-requests)/([1-9][0-9]*)/merge$`)
+
+// PullRequestNumber returns the number of the pull request whose head the ref is, and
+// whether the ref is the head of a pull request.
+func PullRequestNumber(name gitplumbing.ReferenceName) (int, bool) {
+	match := pullRequestRef.FindStringSubmatch(name.String())
+	if match == nil {
+		return 0, false
+	}
+
+	number, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return number, true
+}
+
+// PullRequestMatcher matches the heads of pull requests.
+type PullRequestMatcher struct{}
+
+func NewPullRequestMatcher() *PullRequestMatcher {
+	return new(PullRequestMatcher)
+}
+
+func (*PullRequestMatcher) Matches(name gitplumbing.ReferenceName) bool {
+	_, ok := PullRequestNumber(name)
+	return ok
+}
+
+// PullRequestMergeMatcher matches the merge refs of pull requests, which are only listed
+// to determine which pull requests are open.
+type PullRequestMergeMatcher struct{}
+
+func NewPullRequestMergeMatcher() *PullRequestMergeMatcher {
+	return new(PullRequestMergeMatcher)
+}
+
+func (*PullRequestMergeMatcher) Matches(name gitplumbing.ReferenceName) bool {
+	return pullRequestMergeRef.MatchString(name.String())
+}
+
+// OpenPullRequests returns the refs without the merge refs of pull requests, or the heads
+// of pull requests which have no merge ref, as they are closed.
+func OpenPullRequests(refs Refs) Refs {
+	open := make(Refs, len(refs))
+	for name, hash := range refs {
+		if pullRequestMergeRef.MatchString(name.String()) {
+			continue
+		}
+
+		if pullRequestRef.MatchString(name.String()) {
+			mergeName := gitplumbing.ReferenceName(strings.TrimSuffix(name.String(), "/head") + "/merge")
+			if _, ok := refs[mergeName]; !ok {
+				continue
+			}
+		}
+
+		open[name] = hash
+	}
+
+	return open
+}
+
+// AnyRefMatcher matches the refs matched by any of its matchers.
+type AnyRefMatcher []RefMatcher
+
+func (m AnyRefMatcher) Matches(name gitplumbing.ReferenceName) bool {
+	for _, matcher := range m {
+		if matcher.Matches(name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+)
+
+// Refs maps the names of refs to the hashes of the commits they point to.
+type Refs map[gitplumbing.ReferenceName]gitplumbing.Hash
+
+// ListRemoteRefs lists the refs of the remote repository which are matched by the matcher.
+func ListRemoteRefs(ctx context.Context,
+	logger *slog.Logger,
+	auth transport.AuthMethod,
+	URL string,
+	matcher RefMatcher) (Refs, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{URL}})
+	refs, err := listRemoteRefs(ctx, remote, auth)
+	if err != nil {
+		return nil, fmt.Errorf("listing refs of repository %q: %w", gitauth.RedactURL(URL), err)
+	}
+
+	matched := make(Refs)
+	for _, ref := range refs {
+		if matcher.Matches(ref.Name()) {
+			matched[ref.Name()] = ref.Hash()
+		}
+	}
+
+	logger.InfoContext(ctx, "listed remote refs", "url", gitauth.RedactURL(URL), "matched", len(matched))
+	return matched, nil
+}
+
+// RefDiff is the difference between two listings of the refs of a remote.
+// The names in each are sorted.
+type RefDiff struct {
+	Added   []gitplumbing.ReferenceName
+	Updated []gitplumbing.ReferenceName
+	Removed []gitplumbing.ReferenceName
+}
+
+// DiffRefs returns the refs added, updated to point to a different commit, and removed
+// between the previous and current listings.
+func DiffRefs(previous, current Refs) RefDiff {
+	var diff RefDiff
+	for name, hash := range current {
+		previousHash, ok := previous[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case previousHash != hash:
+			diff.Updated = append(diff.Updated, name)
+		}
+	}
+
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Updated)
+	slices.Sort(diff.Removed)
+
+	return diff
+}
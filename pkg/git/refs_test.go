@@ -0,0 +1,36 @@
+package git
+
+import (
+	"slices"
+	"testing"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestDiffRefs(t *testing.T) {
+	previous := Refs{
+		"refs/heads/feature/a": gitplumbing.NewHash("1111111111111111111111111111111111111111"),
+		"refs/heads/feature/b": gitplumbing.NewHash("2222222222222222222222222222222222222222"),
+		"refs/heads/feature/c": gitplumbing.NewHash("3333333333333333333333333333333333333333"),
+	}
+	current := Refs{
+		"refs/heads/feature/a": gitplumbing.NewHash("1111111111111111111111111111111111111111"),
+		"refs/heads/feature/b": gitplumbing.NewHash("4444444444444444444444444444444444444444"),
+		"refs/heads/feature/e": gitplumbing.NewHash("5555555555555555555555555555555555555555"),
+		"refs/heads/feature/d": gitplumbing.NewHash("6666666666666666666666666666666666666666"),
+	}
+
+	diff := DiffRefs(previous, current)
+
+	if want := []gitplumbing.ReferenceName{"refs/heads/feature/d", "refs/heads/feature/e"}; !slices.Equal(diff.Added, want) {
+		t.Errorf("expected added %v, got %v", want, diff.Added)
+	}
+
+	if want := []gitplumbing.ReferenceName{"refs/heads/feature/b"}; !slices.Equal(diff.Updated, want) {
+		t.Errorf("expected updated %v, got %v", want, diff.Updated)
+	}
+
+	if want := []gitplumbing.ReferenceName{"refs/heads/feature/c"}; !slices.Equal(diff.Removed, want) {
+		t.Errorf("expected removed %v, got %v", want, diff.Removed)
+	}
+}
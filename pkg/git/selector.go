@@ -0,0 +1,213 @@
+package git
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+)
+
+// RefMatcher matches the refs of the remote repository which are watched.
+type RefMatcher interface {
+	// Matches returns whether the ref is watched.
+	Matches(name gitplumbing.ReferenceName) bool
+}
+
+// RefSelector selects the ref of the remote repository which is deployed. Its matches
+// are the candidates for selection.
+type RefSelector interface {
+	RefMatcher
+	// Select returns the selected ref from the refs of the remote. The hashes of the refs
+	// must be those of the commits they point to, i.e. annotated tags must be peeled.
+	Select(refs []*gitplumbing.Reference) (*gitplumbing.Reference, error)
+}
+
+// BranchSelector selects the head of a branch.
+type BranchSelector struct {
+	Branch string
+}
+
+func NewBranchSelector(branch string) *BranchSelector {
+	return &BranchSelector{
+		Branch: branch,
+	}
+}
+
+func (s *BranchSelector) Matches(name gitplumbing.ReferenceName) bool {
+	return name == gitplumbing.NewBranchReferenceName(s.Branch)
+}
+
+func (s *BranchSelector) Select(refs []*gitplumbing.Reference) (*gitplumbing.Reference, error) {
+	// Get the HEAD ref of the target branch, see https://github.com/src-d/go-git/issues/767
+	for _, ref := range refs {
+		if s.Matches(ref.Name()) {
+			return ref, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to locate target branch %q reference", s.Branch)
+}
+
+// RefNameSelector selects the ref of the given name, such as the head of a pull request.
+type RefNameSelector struct {
+	Name gitplumbing.ReferenceName
+}
+
+func NewRefNameSelector(name gitplumbing.ReferenceName) *RefNameSelector {
+	return &RefNameSelector{
+		Name: name,
+	}
+}
+
+func (s *RefNameSelector) Matches(name gitplumbing.ReferenceName) bool {
+	return name == s.Name
+}
+
+func (s *RefNameSelector) Select(refs []*gitplumbing.Reference) (*gitplumbing.Reference, error) {
+	for _, ref := range refs {
+		if s.Matches(ref.Name()) {
+			return ref, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to locate ref %q", s.Name)
+}
+
+// BranchPatternMatcher matches the branches whose names match the glob pattern.
+type BranchPatternMatcher struct {
+	Pattern string
+}
+
+func NewBranchPatternMatcher(pattern string) (*BranchPatternMatcher, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("parsing branch pattern %q: %w", pattern, err)
+	}
+
+	return &BranchPatternMatcher{
+		Pattern: pattern,
+	}, nil
+}
+
+func (m *BranchPatternMatcher) Matches(name gitplumbing.ReferenceName) bool {
+	if !name.IsBranch() {
+		return false
+	}
+
+	matched, _ := path.Match(m.Pattern, name.Short())
+	return matched
+}
+
+// TagSelector selects the tag with the highest semantic version of those whose names
+// match the glob pattern and whose versions satisfy the constraint. Tags which are not
+// semantic versions are ignored.
+type TagSelector struct {
+	Pattern    string
+	Constraint *semver.Constraints
+}
+
+// NewTagSelector returns a selector of the highest tag matching the pattern and
+// constraint. An empty pattern matches any tag, and an empty constraint any version
+// which is not a prerelease.
+func NewTagSelector(pattern, constraint string) (*TagSelector, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("parsing tag pattern %q: %w", pattern, err)
+	}
+
+	if constraint == "" {
+		constraint = "*"
+	}
+
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing tag constraint %q: %w", constraint, err)
+	}
+
+	return &TagSelector{
+		Pattern:    pattern,
+		Constraint: constraints,
+	}, nil
+}
+
+func (s *TagSelector) Matches(name gitplumbing.ReferenceName) bool {
+	_, ok := s.version(name)
+	return ok
+}
+
+func (s *TagSelector) Select(refs []*gitplumbing.Reference) (*gitplumbing.Reference, error) {
+	var selected *gitplumbing.Reference
+	var selectedVersion *semver.Version
+	for _, ref := range refs {
+		version, ok := s.version(ref.Name())
+		if !ok {
+			continue
+		}
+
+		// Tags of equal versions (e.g. "v1.0.0" and "1.0.0") are ordered by name, so that
+		// the selection is stable
+		if selected == nil ||
+			version.GreaterThan(selectedVersion) ||
+			(version.Equal(selectedVersion) && ref.Name() < selected.Name()) {
+			selected, selectedVersion = ref, version
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("unable to locate tag matching pattern %q and constraint %q",
+			s.Pattern,
+			s.Constraint.String())
+	}
+
+	return selected, nil
+}
+
+// version returns the version of the tag, and whether it is a candidate for selection.
+func (s *TagSelector) version(name gitplumbing.ReferenceName) (*semver.Version, bool) {
+	if !name.IsTag() {
+		return nil, false
+	}
+
+	if matched, _ := path.Match(s.Pattern, name.Short()); !matched {
+		return nil, false
+	}
+
+	version, err := semver.NewVersion(name.Short())
+	if err != nil {
+		return nil, false
+	}
+
+	return version, s.Constraint.Check(version)
+}
+
+// peeledSuffix is appended to the name of an annotated tag by the remote to advertise
+// the commit it points to.
+const peeledSuffix = "^{}"
+
+// peel replaces the hashes of annotated tags with those of the commits they point to.
+func peel(refs []*gitplumbing.Reference) []*gitplumbing.Reference {
+	peeled := make(map[gitplumbing.ReferenceName]gitplumbing.Hash)
+	for _, ref := range refs {
+		if name, found := strings.CutSuffix(ref.Name().String(), peeledSuffix); found {
+			peeled[gitplumbing.ReferenceName(name)] = ref.Hash()
+		}
+	}
+
+	result := make([]*gitplumbing.Reference, 0, len(refs))
+	for _, ref := range refs {
+		if strings.HasSuffix(ref.Name().String(), peeledSuffix) || ref.Type() != gitplumbing.HashReference {
+			continue
+		}
+
+		if hash, ok := peeled[ref.Name()]; ok {
+			ref = gitplumbing.NewHashReference(ref.Name(), hash)
+		}
+		result = append(result, ref)
+	}
+
+	return result
+}
@@ -0,0 +1,161 @@
+package git
+
+import (
+	"slices"
+	"testing"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+)
+
+func refs(names ...string) []*gitplumbing.Reference {
+	var result []*gitplumbing.Reference
+	for _, name := range names {
+		result = append(result, gitplumbing.NewHashReference(gitplumbing.ReferenceName(name), gitplumbing.ZeroHash))
+	}
+
+	return result
+}
+
+func TestTagSelectorSelectsHighestMatchingTag(t *testing.T) {
+	candidates := refs("refs/heads/v9.0.0",
+		"refs/tags/v1.2.0",
+		"refs/tags/v1.10.0",
+		"refs/tags/v2.0.0-rc.1",
+		"refs/tags/3.0.0",
+		"refs/tags/release-4.0.0",
+		"refs/tags/latest")
+
+	tests := []struct {
+		pattern    string
+		constraint string
+		want       gitplumbing.ReferenceName
+	}{
+		{pattern: "", constraint: "", want: "refs/tags/3.0.0"},
+		{pattern: "v*", constraint: "", want: "refs/tags/v1.10.0"},
+		{pattern: "v*", constraint: "~1.2", want: "refs/tags/v1.2.0"},
+		{pattern: "v*", constraint: ">=2.0.0-0", want: "refs/tags/v2.0.0-rc.1"},
+	}
+
+	for _, test := range tests {
+		selector, err := NewTagSelector(test.pattern, test.constraint)
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+
+		ref, err := selector.Select(candidates)
+		if err != nil {
+			t.Errorf("expected nil error for %q %q, got %v", test.pattern, test.constraint, err)
+			continue
+		}
+
+		if ref.Name() != test.want {
+			t.Errorf("expected %q for %q %q, got %q", test.want, test.pattern, test.constraint, ref.Name())
+		}
+	}
+}
+
+func TestTagSelectorErrorsWithoutMatch(t *testing.T) {
+	selector, err := NewTagSelector("v*", ">=3.0.0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if _, err := selector.Select(refs("refs/tags/v1.0.0")); err == nil {
+		t.Error("expected error when no tag matches, got nil")
+	}
+}
+
+func TestNewTagSelectorRejectsBadConstraint(t *testing.T) {
+	if _, err := NewTagSelector("", "not a constraint"); err == nil {
+		t.Error("expected error for bad constraint, got nil")
+	}
+}
+
+func TestPeel(t *testing.T) {
+	tagHash := gitplumbing.NewHash("1111111111111111111111111111111111111111")
+	commitHash := gitplumbing.NewHash("2222222222222222222222222222222222222222")
+
+	peeled := peel([]*gitplumbing.Reference{
+		gitplumbing.NewHashReference("refs/tags/v1.0.0", tagHash),
+		gitplumbing.NewHashReference("refs/tags/v1.0.0^{}", commitHash),
+		gitplumbing.NewHashReference("refs/heads/main", commitHash),
+	})
+
+	if len(peeled) != 2 {
+		t.Fatalf("expected 2 refs, got %d", len(peeled))
+	}
+
+	for _, ref := range peeled {
+		if ref.Hash() != commitHash {
+			t.Errorf("expected %q to be peeled to %s, got %s", ref.Name(), commitHash, ref.Hash())
+		}
+	}
+}
+
+func TestBranchPatternMatcherMatchesBranches(t *testing.T) {
+	matcher, err := NewBranchPatternMatcher("feature/*")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	for name, want := range map[gitplumbing.ReferenceName]bool{
+		"refs/heads/feature/login":     true,
+		"refs/heads/feature/login/fix": false,
+		"refs/heads/main":              false,
+		"refs/tags/feature/login":      false,
+	} {
+		if got := matcher.Matches(name); got != want {
+			t.Errorf("expected %v for %q, got %v", want, name, got)
+		}
+	}
+}
+
+func TestPullRequestMatcherMatchesPullRequestHeads(t *testing.T) {
+	matcher := NewPullRequestMatcher()
+
+	for name, want := range map[gitplumbing.ReferenceName]int{
+		"refs/pull/12/head":           12,
+		"refs/merge-requests/3/head":  3,
+		"refs/pull/12/merge":          0,
+		"refs/heads/pull/12/head":     0,
+		"refs/merge-requests/03/head": 0,
+	} {
+		number, ok := PullRequestNumber(name)
+		if number != want || ok != (want != 0) {
+			t.Errorf("expected %d for %q, got %d", want, name, number)
+		}
+
+		if matcher.Matches(name) != (want != 0) {
+			t.Errorf("expected match of %q to be %v", name, want != 0)
+		}
+	}
+}
+
+func TestOpenPullRequestsHaveMergeRefs(t *testing.T) {
+	hash := gitplumbing.NewHash("1111111111111111111111111111111111111111")
+	listed := Refs{
+		"refs/heads/feature/a":        hash,
+		"refs/pull/1/head":            hash,
+		"refs/pull/1/merge":           hash,
+		"refs/pull/2/head":            hash,
+		"refs/merge-requests/3/head":  hash,
+		"refs/merge-requests/3/merge": hash,
+	}
+
+	open := OpenPullRequests(listed)
+
+	expected := []gitplumbing.ReferenceName{"refs/heads/feature/a", "refs/merge-requests/3/head", "refs/pull/1/head"}
+	names := make([]gitplumbing.ReferenceName, 0, len(open))
+	for name := range open {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if !slices.Equal(names, expected) {
+		t.Errorf("expected refs %v, got %v", expected, names)
+	}
+
+	if matcher := NewPullRequestMergeMatcher(); !matcher.Matches("refs/pull/1/merge") || matcher.Matches("refs/pull/1/head") {
+		t.Error("expected only merge refs to be matched")
+	}
+}
@@ -0,0 +1,138 @@
+package gitauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
+)
+
+// defaultHTTPSUser is the user presented alongside a token when none is configured.
+// Git hosting services ignore the user when authenticating with a token, but it must
+// not be empty.
+const defaultHTTPSUser = "git"
+
+// BasicAuthProvider authenticates to an HTTPS remote using a fixed username and
+// password or token.
+type BasicAuthProvider struct {
+	Username string
+	Password string
+}
+
+func NewBasicAuthProvider(username, password string) *BasicAuthProvider {
+	if username == "" {
+		username = defaultHTTPSUser
+	}
+
+	return &BasicAuthProvider{
+		Username: username,
+		Password: password,
+	}
+}
+
+func (p *BasicAuthProvider) AuthMethod(ctx context.Context) (transport.AuthMethod, error) {
+	return &githttp.BasicAuth{Username: p.Username, Password: p.Password}, nil
+}
+
+// FileProvider authenticates to an HTTPS remote using a password or token read from
+// a file, such as a mounted secret. The file is read upon each communication.
+type FileProvider struct {
+	Username string
+	Path     string
+}
+
+func NewFileProvider(username, path string) *FileProvider {
+	if username == "" {
+		username = defaultHTTPSUser
+	}
+
+	return &FileProvider{
+		Username: username,
+		Path:     path,
+	}
+}
+
+func (p *FileProvider) AuthMethod(ctx context.Context) (transport.AuthMethod, error) {
+	contents, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credential file %q: %w", p.Path, err)
+	}
+
+	password := strings.TrimSpace(string(contents))
+	if password == "" {
+		return nil, fmt.Errorf("credential file %q is empty", p.Path)
+	}
+
+	return &githttp.BasicAuth{Username: p.Username, Password: password}, nil
+}
+
+// CredentialHelperProvider authenticates to an HTTPS remote using the credentials
+// returned by an executable implementing the "get" action of the Git credential helper
+// protocol. See https://git-scm.com/docs/git-credential#IOFMT
+type CredentialHelperProvider struct {
+	Command  string
+	Endpoint *transport.Endpoint
+}
+
+func NewCredentialHelperProvider(command string, endpoint *transport.Endpoint) *CredentialHelperProvider {
+	return &CredentialHelperProvider{
+		Command:  command,
+		Endpoint: endpoint,
+	}
+}
+
+func (p *CredentialHelperProvider) AuthMethod(ctx context.Context) (transport.AuthMethod, error) {
+	fields := strings.Fields(p.Command)
+	if len(fields) == 0 {
+		return nil, errors.New("credential helper command is empty")
+	}
+
+	host := p.Endpoint.Host
+	if p.Endpoint.Port != 0 {
+		host = fmt.Sprintf("%s:%d", host, p.Endpoint.Port)
+	}
+
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "protocol=%s\n", p.Endpoint.Protocol)
+	fmt.Fprintf(&input, "host=%s\n", host)
+	fmt.Fprintf(&input, "path=%s\n", strings.TrimPrefix(p.Endpoint.Path, "/"))
+	input.WriteString("\n")
+
+	// The output is deliberately not streamed to the log, as it contains the credentials
+	cmd := executil.Command(ctx, fields[0], append(fields[1:], "get")...)
+	cmd.Stdin = &input
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running credential helper %q: %w", fields[0], err)
+	}
+
+	auth := &githttp.BasicAuth{Username: defaultHTTPSUser}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "username":
+			auth.Username = value
+		case "password":
+			auth.Password = value
+		}
+	}
+
+	if auth.Password == "" {
+		return nil, fmt.Errorf("credential helper %q returned no password", fields[0])
+	}
+
+	return auth, nil
+}
@@ -0,0 +1,105 @@
+package gitauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// Provider provides the authentication method used to communicate with a Git remote.
+// It is called upon each communication, so that rotated credentials are picked up.
+type Provider interface {
+	AuthMethod(ctx context.Context) (transport.AuthMethod, error)
+}
+
+// AnonymousProvider communicates with the remote without authenticating.
+type AnonymousProvider struct{}
+
+func NewAnonymousProvider() *AnonymousProvider {
+	return new(AnonymousProvider)
+}
+
+func (*AnonymousProvider) AuthMethod(ctx context.Context) (transport.AuthMethod, error) {
+	return nil, nil
+}
+
+// defaultSSHUser is the user used for SSH remotes whose URL does not specify one,
+// as is the convention for Git hosting services.
+const defaultSSHUser = "git"
+
+// SSHProvider authenticates to an SSH remote using either a private key file or the
+// keys held by the SSH agent. The remote's host key is strictly checked against the
+// known_hosts file.
+type SSHProvider struct {
+	User           string
+	Addr           string
+	KeyPath        string
+	KeyPassphrase  string
+	KnownHostsPath string
+}
+
+// NewSSHProvider returns a provider for the SSH remote at the given endpoint.
+// If keyPath is empty, the SSH agent is used.
+func NewSSHProvider(endpoint *transport.Endpoint,
+	keyPath, keyPassphrase, knownHostsPath string) (*SSHProvider, error) {
+	if knownHostsPath == "" {
+		return nil, errors.New("a known_hosts file is required for SSH remotes")
+	}
+
+	user := endpoint.User
+	if user == "" {
+		user = defaultSSHUser
+	}
+
+	port := endpoint.Port
+	if port == 0 {
+		port = 22
+	}
+
+	return &SSHProvider{
+		User:           user,
+		Addr:           net.JoinHostPort(endpoint.Host, strconv.Itoa(port)),
+		KeyPath:        keyPath,
+		KeyPassphrase:  keyPassphrase,
+		KnownHostsPath: knownHostsPath,
+	}, nil
+}
+
+func (p *SSHProvider) AuthMethod(ctx context.Context) (transport.AuthMethod, error) {
+	// Only the configured known_hosts file is used, rather than any from the user's
+	// home directory, so that it is the sole source of trust
+	knownHosts, err := gitssh.NewKnownHostsDb(p.KnownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading known_hosts file %q: %w", p.KnownHostsPath, err)
+	}
+
+	hostKeyHelper := gitssh.HostKeyCallbackHelper{
+		HostKeyCallback: knownHosts.HostKeyCallback(),
+		// Negotiate only the host key types known for the host, otherwise the server may
+		// present a type of key which is not in the known_hosts file and be rejected
+		HostKeyAlgorithms: knownHosts.HostKeyAlgorithms(p.Addr),
+	}
+
+	if p.KeyPath == "" {
+		auth, err := gitssh.NewSSHAgentAuth(p.User)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to SSH agent: %w", err)
+		}
+		auth.HostKeyCallbackHelper = hostKeyHelper
+
+		return auth, nil
+	}
+
+	auth, err := gitssh.NewPublicKeysFromFile(p.User, p.KeyPath, p.KeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("loading SSH private key %q: %w", p.KeyPath, err)
+	}
+	auth.HostKeyCallbackHelper = hostKeyHelper
+
+	return auth, nil
+}
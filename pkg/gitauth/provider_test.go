@@ -0,0 +1,118 @@
+package gitauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newSigner(t *testing.T) (ssh.Signer, []byte) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatalf("marshalling key: %v", err)
+	}
+
+	return signer, pem.EncodeToMemory(block)
+}
+
+func TestNewSSHProviderDefaults(t *testing.T) {
+	endpoint, err := transport.NewEndpoint("git.example.com:org/repo.git")
+	if err != nil {
+		t.Fatalf("parsing endpoint: %v", err)
+	}
+
+	provider, err := NewSSHProvider(endpoint, "", "", "known_hosts")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if provider.User != defaultSSHUser {
+		t.Errorf("expected user %q, got %q", defaultSSHUser, provider.User)
+	}
+
+	if provider.Addr != "git.example.com:22" {
+		t.Errorf("expected addr %q, got %q", "git.example.com:22", provider.Addr)
+	}
+}
+
+func TestNewSSHProviderRequiresKnownHosts(t *testing.T) {
+	endpoint, err := transport.NewEndpoint("ssh://deploy@git.example.com:2222/org/repo.git")
+	if err != nil {
+		t.Fatalf("parsing endpoint: %v", err)
+	}
+
+	if _, err := NewSSHProvider(endpoint, "id_ed25519", "", ""); err == nil {
+		t.Error("expected error without known_hosts file, got nil")
+	}
+}
+
+func TestSSHProviderChecksHostKey(t *testing.T) {
+	dir := t.TempDir()
+	knownHost, _ := newSigner(t)
+	unknownHost, _ := newSigner(t)
+	_, clientKey := newSigner(t)
+
+	addr := "git.example.com:2222"
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, knownHost.PublicKey()) + "\n"
+	if err := os.WriteFile(knownHostsPath, []byte(line), 0o600); err != nil {
+		t.Fatalf("writing known_hosts: %v", err)
+	}
+
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, clientKey, 0o600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+
+	provider := &SSHProvider{
+		User:           "deploy",
+		Addr:           addr,
+		KeyPath:        keyPath,
+		KnownHostsPath: knownHostsPath,
+	}
+
+	auth, err := provider.AuthMethod(context.Background())
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	config, err := auth.(*gitssh.PublicKeys).ClientConfig()
+	if err != nil {
+		t.Fatalf("creating client config: %v", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2222}
+	if err := config.HostKeyCallback(addr, remote, knownHost.PublicKey()); err != nil {
+		t.Errorf("expected known host key to be accepted, got %v", err)
+	}
+
+	if err := config.HostKeyCallback(addr, remote, unknownHost.PublicKey()); err == nil {
+		t.Error("expected unknown host key to be rejected, got nil")
+	}
+
+	if len(config.HostKeyAlgorithms) == 0 {
+		t.Error("expected host key algorithms to be restricted to those known")
+	}
+}
@@ -0,0 +1,68 @@
+package gitauth
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const redacted = "xxxxx"
+
+// RedactURL returns the URL with any password it contains redacted, so that it may
+// be logged or included in an error.
+func RedactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		// Could be an SCP-like SSH address, which never contains a password
+		return rawURL
+	}
+
+	return parsed.Redacted()
+}
+
+// RedactError returns an error whose message has any password held by the auth method,
+// or contained within the URL, redacted. The original error can still be unwrapped.
+func RedactError(err error, auth transport.AuthMethod, rawURL string) error {
+	if err == nil {
+		return nil
+	}
+
+	var secrets []string
+	if basicAuth, ok := auth.(*githttp.BasicAuth); ok && basicAuth.Password != "" {
+		secrets = append(secrets, basicAuth.Password)
+	}
+
+	if parsed, parseErr := url.Parse(rawURL); parseErr == nil && parsed.User != nil {
+		if password, ok := parsed.User.Password(); ok && password != "" {
+			secrets = append(secrets, password)
+		}
+	}
+
+	msg := err.Error()
+	for _, secret := range secrets {
+		msg = strings.ReplaceAll(msg, secret, redacted)
+		// The URL may appear escaped within the message
+		msg = strings.ReplaceAll(msg, url.QueryEscape(secret), redacted)
+	}
+
+	if msg == err.Error() {
+		return err
+	}
+
+	return &redactedError{msg: msg, err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,162 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reporter is notified of the progress of the pipeline, so that its health can be determined.
+type Reporter interface {
+	// Ready reports that setup has completed.
+	Ready()
+
+	// Beat reports that an iteration of the reconciliation loop has completed.
+	Beat()
+
+	// Wait reports that the reconciliation loop is waiting for something which may take
+	// until the deadline, such as a stage, or indefinitely if it is zero. It is live until
+	// the liveness timeout after the deadline, unless it beats again first.
+	Wait(deadline time.Time)
+}
+
+// Monitor determines health from the reports made to it, and serves it over HTTP.
+// The pipeline is live if it has not yet started the reconciliation loop, or if an
+// iteration has completed within the liveness timeout, or it is waiting for something
+// whose deadline passed no longer ago. It is ready once setup has completed.
+// A pipeline made up of others, such as those of preview environments, is only live if
+// each of its components is too.
+type Monitor struct {
+	LivenessTimeout time.Duration
+
+	mu         sync.Mutex
+	ready      bool
+	lastBeat   time.Time
+	waiting    bool
+	deadline   time.Time
+	components map[string]*Monitor
+}
+
+func NewMonitor(livenessTimeout time.Duration) *Monitor {
+	return &Monitor{
+		LivenessTimeout: livenessTimeout,
+	}
+}
+
+func (m *Monitor) Ready() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ready = true
+}
+
+func (m *Monitor) Beat() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastBeat = time.Now()
+	m.waiting = false
+}
+
+func (m *Monitor) Wait(deadline time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.waiting = true
+	m.deadline = deadline
+}
+
+// Component returns the monitor of the named component, with the same liveness timeout,
+// replacing any of the same name. The readiness of components is not monitored, as one
+// failing setup does not stop the others.
+func (m *Monitor) Component(name string) *Monitor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	component := NewMonitor(m.LivenessTimeout)
+	if m.components == nil {
+		m.components = make(map[string]*Monitor)
+	}
+	m.components[name] = component
+
+	return component
+}
+
+// RemoveComponent stops monitoring the named component.
+func (m *Monitor) RemoveComponent(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.components, name)
+}
+
+func (m *Monitor) live() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, component := range m.components {
+		if err := component.live(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	// Setup may legitimately take a long time, and has its own timeouts
+	if m.lastBeat.IsZero() {
+		return nil
+	}
+
+	// Long stages are not stuck until their deadline has passed
+	if m.waiting {
+		if m.deadline.IsZero() {
+			return nil
+		}
+
+		if sinceDeadline := time.Since(m.deadline); sinceDeadline > m.LivenessTimeout {
+			return fmt.Errorf("reconciliation loop has waited for %v beyond the deadline", sinceDeadline.Round(time.Second))
+		}
+
+		return nil
+	}
+
+	if sinceBeat := time.Since(m.lastBeat); sinceBeat > m.LivenessTimeout {
+		return fmt.Errorf("reconciliation loop has not completed an iteration for %v", sinceBeat.Round(time.Second))
+	}
+
+	return nil
+}
+
+func (m *Monitor) isReady() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.ready {
+		return fmt.Errorf("setup has not completed")
+	}
+
+	return nil
+}
+
+// LivenessHandler serves the liveness of the pipeline.
+func (m *Monitor) LivenessHandler() http.Handler {
+	return probeHandler(m.live)
+}
+
+// ReadinessHandler serves the readiness of the pipeline.
+func (m *Monitor) ReadinessHandler() http.Handler {
+	return probeHandler(m.isReady)
+}
+
+func probeHandler(probe func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if err := probe(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+}
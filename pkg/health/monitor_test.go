@@ -0,0 +1,106 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, handler http.Handler) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	return rec.Code
+}
+
+func TestReadinessReflectsSetup(t *testing.T) {
+	monitor := NewMonitor(time.Minute)
+
+	if code := probe(t, monitor.ReadinessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d before ready, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	monitor.Ready()
+
+	if code := probe(t, monitor.ReadinessHandler()); code != http.StatusOK {
+		t.Errorf("expected status %d once ready, got %d", http.StatusOK, code)
+	}
+}
+
+func TestLivenessFailsWithoutRecentBeat(t *testing.T) {
+	monitor := NewMonitor(time.Minute)
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("expected status %d before first beat, got %d", http.StatusOK, code)
+	}
+
+	monitor.Beat()
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("expected status %d after recent beat, got %d", http.StatusOK, code)
+	}
+
+	monitor.lastBeat = time.Now().Add(-2 * time.Minute)
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d after stale beat, got %d", http.StatusServiceUnavailable, code)
+	}
+}
+
+func TestLivenessFailsWithoutRecentBeatOfComponent(t *testing.T) {
+	monitor := NewMonitor(time.Minute)
+	monitor.Beat()
+
+	component := monitor.Component("feature/a")
+	component.Beat()
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("expected status %d after recent beat of component, got %d", http.StatusOK, code)
+	}
+
+	component.lastBeat = time.Now().Add(-2 * time.Minute)
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d after stale beat of component, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	monitor.RemoveComponent("feature/a")
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("expected status %d once component removed, got %d", http.StatusOK, code)
+	}
+}
+
+func TestLivenessWhileWaiting(t *testing.T) {
+	monitor := NewMonitor(time.Minute)
+	monitor.Beat()
+	monitor.lastBeat = time.Now().Add(-2 * time.Minute)
+
+	monitor.Wait(time.Now().Add(time.Hour))
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("expected status %d while waiting before deadline, got %d", http.StatusOK, code)
+	}
+
+	monitor.Wait(time.Now().Add(-2 * time.Minute))
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d while waiting long after deadline, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	monitor.Wait(time.Time{})
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("expected status %d while waiting without deadline, got %d", http.StatusOK, code)
+	}
+
+	monitor.Beat()
+	monitor.lastBeat = time.Now().Add(-2 * time.Minute)
+
+	if code := probe(t, monitor.LivenessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d once waiting ended by stale beat, got %d", http.StatusServiceUnavailable, code)
+	}
+}
@@ -0,0 +1,116 @@
+package history
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+type Outcome string
+
+const (
+	OutcomeSucceeded Outcome = "succeeded"
+	OutcomeFailed    Outcome = "failed"
+	OutcomeSkipped   Outcome = "skipped"
+	OutcomeCancelled Outcome = "cancelled"
+)
+
+type Reason string
+
+const (
+	ReasonSetup  Reason = "setup"
+	ReasonChange Reason = "change"
+)
+
+// Run is the record of a single invocation of the pipeline.
+type Run struct {
+	ID         string    `json:"id"`
+	Reason     Reason    `json:"reason"`
+	Commit     string    `json:"commit,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	ImageName  string    `json:"imageName"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Stages     []Stage   `json:"stages,omitempty"`
+	Outcome    Outcome   `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	LogPath    string    `json:"logPath,omitempty"`
+	// Ref is the ref deployed by the run, if the store holds the runs of more than one.
+	Ref string `json:"ref,omitempty"`
+	// PreviousCommit is the commit checked out before the update which led to the run.
+	PreviousCommit string `json:"previousCommit,omitempty"`
+	// HistoryRewritten is set if the previous commit is not an ancestor of the commit,
+	// such as after a force-push.
+	HistoryRewritten bool `json:"historyRewritten,omitempty"`
+	// ImageExisted is set if the image was already in the registry, so was not built or pushed.
+	ImageExisted bool `json:"imageExisted,omitempty"`
+}
+
+// Stage is the record of a single stage of a pipeline run.
+type Stage struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"durationNs"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func NewRun(reason Reason, imageName string) *Run {
+	return &Run{
+		ID:        newID(),
+		Reason:    reason,
+		ImageName: imageName,
+		StartedAt: time.Now(),
+	}
+}
+
+// TimeStage runs the given stage, recording its duration and any error.
+func (r *Run) TimeStage(name string, stage func() error) error {
+	start := time.Now()
+	err := stage()
+
+	record := Stage{
+		Name:     name,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	r.Stages = append(r.Stages, record)
+
+	return err
+}
+
+// Finish completes the run, setting the outcome according to the error.
+func (r *Run) Finish(err error) {
+	r.FinishedAt = time.Now()
+
+	if err != nil {
+		r.Outcome = OutcomeFailed
+		r.Error = err.Error()
+		return
+	}
+
+	r.Outcome = OutcomeSucceeded
+}
+
+// Cancel completes the run, recording that it was cancelled before it could finish.
+func (r *Run) Cancel(err error) {
+	r.FinishedAt = time.Now()
+	r.Outcome = OutcomeCancelled
+	r.Error = err.Error()
+}
+
+// Skip completes the run without any stages having been performed.
+func (r *Run) Skip() {
+	r.FinishedAt = time.Now()
+	r.Outcome = OutcomeSkipped
+}
+
+func newID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		// The system random source failing is not recoverable
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
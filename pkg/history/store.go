@@ -0,0 +1,219 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists a record of every pipeline run.
+type Store interface {
+	Record(run *Run) error
+	LastSucceeded() (*Run, error)
+	// LastProcessed returns the most recent run which succeeded or was skipped, or nil if
+	// there has been none.
+	LastProcessed() (*Run, error)
+}
+
+// maxLineLength is the length beyond which lines of the history file are skipped when read.
+// Error text from failed stages may make lines long.
+const maxLineLength = 1 << 20
+
+// JSONLinesStore appends each run as a line of JSON to a file.
+// Lines which cannot be read, such as one truncated by a crash mid-write, are skipped.
+type JSONLinesStore struct {
+	Path   string
+	Logger *slog.Logger
+
+	mu sync.Mutex
+}
+
+func NewJSONLinesStore(path string, logger *slog.Logger) *JSONLinesStore {
+	return &JSONLinesStore{
+		Path:   path,
+		Logger: logger,
+	}
+}
+
+func (s *JSONLinesStore) Record(run *Run) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("encoding run %q: %w", run.ID, err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("creating history directory for %q: %w", s.Path, err)
+	}
+
+	file, err := os.OpenFile(s.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening history file %q: %w", s.Path, err)
+	}
+	defer file.Close()
+
+	// A line truncated by a crash mid-write is terminated, so that the run is not lost with it
+	terminated, err := endsWithNewline(file)
+	if err != nil {
+		return fmt.Errorf("reading end of history file %q: %w", s.Path, err)
+	}
+	if !terminated {
+		line = append([]byte{'\n'}, line...)
+	}
+
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("writing run %q to history file %q: %w", run.ID, s.Path, err)
+	}
+
+	// Ensure the record survives a crash or restart
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("syncing history file %q: %w", s.Path, err)
+	}
+
+	return nil
+}
+
+// LastSucceeded returns the most recent successful run, or nil if there has been none.
+func (s *JSONLinesStore) LastSucceeded() (*Run, error) {
+	return s.last(func(run *Run) bool { return succeeded(run) })
+}
+
+// LastSucceededForRef returns the most recent successful run which deployed the ref,
+// or nil if there has been none.
+func (s *JSONLinesStore) LastSucceededForRef(ref string) (*Run, error) {
+	return s.last(func(run *Run) bool { return succeeded(run) && run.Ref == ref })
+}
+
+// LastProcessed returns the most recent run which succeeded or was skipped, or nil if
+// there has been none.
+func (s *JSONLinesStore) LastProcessed() (*Run, error) {
+	return s.last(processed)
+}
+
+// LastProcessedForRef returns the most recent run of the ref which succeeded or was
+// skipped, or nil if there has been none.
+func (s *JSONLinesStore) LastProcessedForRef(ref string) (*Run, error) {
+	return s.last(func(run *Run) bool { return processed(run) && run.Ref == ref })
+}
+
+func succeeded(run *Run) bool {
+	return run.Outcome == OutcomeSucceeded
+}
+
+// processed determines if the run left its commit deployed, either by deploying it or by
+// finding it had no need to.
+func processed(run *Run) bool {
+	return run.Outcome == OutcomeSucceeded || run.Outcome == OutcomeSkipped
+}
+
+func (s *JSONLinesStore) last(matches func(run *Run) bool) (*Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.Path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil // No history yet
+	} else if err != nil {
+		return nil, fmt.Errorf("opening history file %q: %w", s.Path, err)
+	}
+	defer file.Close()
+
+	var last *Run
+	reader := bufio.NewReaderSize(file, maxLineLength)
+	for lineNumber := 1; ; lineNumber++ {
+		line, tooLong, err := readLine(reader)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("reading history file %q: %w", s.Path, err)
+		}
+
+		if tooLong {
+			s.Logger.Warn("skipping overlong line of history file", "path", s.Path, "line", lineNumber)
+		} else if len(line) > 0 {
+			run := new(Run)
+			if err := json.Unmarshal(line, run); err != nil {
+				s.Logger.Warn("skipping malformed line of history file", "path", s.Path, "line", lineNumber, "error", err)
+			} else if matches(run) {
+				last = run
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return last, nil
+}
+
+// endsWithNewline determines if the file is empty or its last line is terminated.
+func endsWithNewline(file *os.File) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if info.Size() == 0 {
+		return true, nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+
+	return last[0] == '\n', nil
+}
+
+// readLine reads the next line, without its newline. A line too long for the reader's
+// buffer is discarded, and reported as too long.
+func readLine(reader *bufio.Reader) ([]byte, bool, error) {
+	line, err := reader.ReadSlice('\n')
+	tooLong := false
+	for errors.Is(err, bufio.ErrBufferFull) {
+		tooLong = true
+		_, err = reader.ReadSlice('\n')
+	}
+
+	return bytes.TrimSuffix(line, []byte("\n")), tooLong, err
+}
+
+// RefStore records the runs of the pipeline deploying a single ref in a store shared with
+// the pipelines deploying other refs.
+type RefStore struct {
+	Ref   string
+	Store *JSONLinesStore
+}
+
+func NewRefStore(ref string, store *JSONLinesStore) *RefStore {
+	return &RefStore{
+		Ref:   ref,
+		Store: store,
+	}
+}
+
+func (s *RefStore) Record(run *Run) error {
+	run.Ref = s.Ref
+	return s.Store.Record(run)
+}
+
+// LastSucceeded returns the most recent successful run which deployed the ref, or nil if
+// there has been none.
+func (s *RefStore) LastSucceeded() (*Run, error) {
+	return s.Store.LastSucceededForRef(s.Ref)
+}
+
+// LastProcessed returns the most recent run of the ref which succeeded or was skipped, or
+// nil if there has been none.
+func (s *RefStore) LastProcessed() (*Run, error) {
+	return s.Store.LastProcessedForRef(s.Ref)
+}
@@ -0,0 +1,157 @@
+package history
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func newStore(t *testing.T) *JSONLinesStore {
+	t.Helper()
+
+	return NewJSONLinesStore(filepath.Join(t.TempDir(), "history", "history.jsonl"), discardLogger)
+}
+
+func record(t *testing.T, store Store, runs ...*Run) {
+	t.Helper()
+
+	for _, run := range runs {
+		if err := store.Record(run); err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+	}
+}
+
+func TestJSONLinesStoreWithoutHistory(t *testing.T) {
+	store := newStore(t)
+
+	last, err := store.LastSucceeded()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if last != nil {
+		t.Errorf("expected no run, got %+v", last)
+	}
+}
+
+func TestJSONLinesStoreLastSucceededAndProcessed(t *testing.T) {
+	store := newStore(t)
+	record(t, store,
+		&Run{ID: "succeeded", Outcome: OutcomeSucceeded},
+		&Run{ID: "skipped", Outcome: OutcomeSkipped},
+		&Run{ID: "failed", Outcome: OutcomeFailed},
+		&Run{ID: "cancelled", Outcome: OutcomeCancelled})
+
+	succeeded, err := store.LastSucceeded()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if succeeded == nil || succeeded.ID != "succeeded" {
+		t.Errorf("expected last successful run %q, got %+v", "succeeded", succeeded)
+	}
+
+	processed, err := store.LastProcessed()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if processed == nil || processed.ID != "skipped" {
+		t.Errorf("expected last processed run %q, got %+v", "skipped", processed)
+	}
+}
+
+func TestRefStoreLastSucceededForRef(t *testing.T) {
+	store := newStore(t)
+	mainStore := NewRefStore("refs/heads/main", store)
+	feature := NewRefStore("refs/heads/feature", store)
+	record(t, mainStore, &Run{ID: "main", Outcome: OutcomeSucceeded})
+	record(t, feature, &Run{ID: "feature", Outcome: OutcomeSucceeded})
+	record(t, mainStore, &Run{ID: "main-failed", Outcome: OutcomeFailed})
+
+	last, err := mainStore.LastSucceeded()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if last == nil || last.ID != "main" || last.Ref != "refs/heads/main" {
+		t.Errorf("expected last successful run %q of ref, got %+v", "main", last)
+	}
+
+	last, err = store.LastSucceededForRef("refs/heads/other")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if last != nil {
+		t.Errorf("expected no run of unrecorded ref, got %+v", last)
+	}
+}
+
+func TestJSONLinesStoreSkipsUnreadableLines(t *testing.T) {
+	store := newStore(t)
+	record(t, store, &Run{ID: "first", Outcome: OutcomeSucceeded})
+
+	file, err := os.OpenFile(store.Path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("opening history file: %v", err)
+	}
+	overlong := `{"id":"overlong","outcome":"succeeded","error":"` + strings.Repeat("x", maxLineLength) + "\"}\n"
+	if _, err := file.WriteString(overlong + "not json\n"); err != nil {
+		t.Fatalf("writing history file: %v", err)
+	}
+	file.Close()
+
+	record(t, store, &Run{ID: "last", Outcome: OutcomeSucceeded})
+
+	last, err := store.LastSucceeded()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if last == nil || last.ID != "last" {
+		t.Errorf("expected last successful run %q, got %+v", "last", last)
+	}
+}
+
+func TestJSONLinesStoreToleratesTruncatedLastLine(t *testing.T) {
+	store := newStore(t)
+	record(t, store, &Run{ID: "first", Outcome: OutcomeSucceeded})
+
+	// A crash mid-write leaves the last line truncated
+	file, err := os.OpenFile(store.Path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("opening history file: %v", err)
+	}
+	if _, err := file.WriteString(`{"id":"truncated","outcome":"succ`); err != nil {
+		t.Fatalf("writing history file: %v", err)
+	}
+	file.Close()
+
+	last, err := store.LastSucceeded()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if last == nil || last.ID != "first" {
+		t.Errorf("expected last successful run %q, got %+v", "first", last)
+	}
+
+	// The run recorded next is not appended to the truncated line
+	record(t, store, &Run{ID: "next", Outcome: OutcomeSucceeded})
+
+	last, err = store.LastSucceeded()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if last == nil || last.ID != "next" {
+		t.Errorf("expected last successful run %q, got %+v", "next", last)
+	}
+}
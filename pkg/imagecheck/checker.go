@@ -0,0 +1,56 @@
+package imagecheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Checker checks whether an image has already been pushed.
+type Checker interface {
+	Exists(ctx context.Context, name, tag string) (bool, error)
+}
+
+// RegistryChecker checks whether the registry has the manifest of an image, using the
+// Docker Registry HTTP API v2 and authenticating with the credentials of the Docker
+// config, if any.
+type RegistryChecker struct {
+	Logger *slog.Logger
+}
+
+func NewRegistryChecker(logger *slog.Logger) *RegistryChecker {
+	return &RegistryChecker{
+		Logger: logger,
+	}
+}
+
+func (c *RegistryChecker) Exists(ctx context.Context, imageName, tag string) (bool, error) {
+	/*
+		curl -I "https://${registry}/v2/${repository}/manifests/${image_tag}"
+	*/
+
+	fullImageName := imageName + ":" + tag
+	ref, err := name.NewTag(fullImageName)
+	if err != nil {
+		return false, fmt.Errorf("parsing image name %q: %w", fullImageName, err)
+	}
+
+	_, err = remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if transportErr := new(transport.Error); errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+		c.Logger.InfoContext(ctx, "image not found in registry", "image", fullImageName)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("checking for image %q in registry: %w", fullImageName, err)
+	}
+
+	c.Logger.InfoContext(ctx, "image found in registry", "image", fullImageName)
+	return true, nil
+}
@@ -0,0 +1,70 @@
+package imagecheck
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestRegistryCheckerExists(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	ref, err := name.NewTag(host + "/app:v1.0.0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	tests := []struct {
+		imageName string
+		tag       string
+		expected  bool
+	}{
+		{host + "/app", "v1.0.0", true},
+		{host + "/app", "v1.0.1", false},
+		{host + "/other", "v1.0.0", false},
+	}
+
+	c := NewRegistryChecker(discardLogger)
+	for _, test := range tests {
+		exists, err := c.Exists(context.Background(), test.imageName, test.tag)
+		if err != nil {
+			t.Errorf("expected nil error checking for %s:%s, got %v", test.imageName, test.tag, err)
+			continue
+		}
+
+		if exists != test.expected {
+			t.Errorf("expected existence of %s:%s to be %t, got %t", test.imageName, test.tag, test.expected, exists)
+		}
+	}
+}
+
+func TestRegistryCheckerErrorsUponUnreachableRegistry(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	host := strings.TrimPrefix(server.URL, "http://")
+	server.Close()
+
+	if _, err := NewRegistryChecker(discardLogger).Exists(context.Background(), host+"/app", "v1.0.0"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,75 @@
+package install
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
+)
+
+// Inspector reports the image currently installed.
+// An empty image name and tag are returned if nothing is installed.
+type Inspector interface {
+	Installed(ctx context.Context) (imageName, imageTag string, err error)
+}
+
+// helmStatus is the subset of the output of "helm status -o json" that is of interest.
+type helmStatus struct {
+	Info struct {
+		Status string `json:"status"`
+	} `json:"info"`
+	Config struct {
+		Image struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"image"`
+	} `json:"config"`
+}
+
+func (i *HelmK8sAtomicInstaller) Installed(ctx context.Context) (string, string, error) {
+	/*
+		helm status \
+			-n "$k8s_namespace" \
+			-o json \
+			"$release_name"
+	*/
+
+	i.Logger.InfoContext(ctx, "getting status of helm release", "release", i.ReleaseName, "namespace", i.K8sNamespace)
+
+	cmd := executil.Command(ctx, "helm",
+		"status",
+		"-n", i.K8sNamespace,
+		"-o", "json",
+		i.ReleaseName)
+
+	i.Logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	output, err := cmd.Output()
+	exitErr := new(exec.ExitError)
+	if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "release: not found") {
+		i.Logger.InfoContext(ctx, "helm release not found", "release", i.ReleaseName)
+		return "", "", nil
+	} else if errors.As(err, &exitErr) {
+		return "", "", fmt.Errorf("running helm status command for release %q: %w",
+			i.ReleaseName,
+			&executil.Error{Err: err, StderrTail: []string{strings.TrimSpace(string(exitErr.Stderr))}})
+	} else if err != nil {
+		return "", "", fmt.Errorf("running helm status command for release %q: %w", i.ReleaseName, err)
+	}
+
+	status := new(helmStatus)
+	if err := json.Unmarshal(output, status); err != nil {
+		return "", "", fmt.Errorf("decoding helm status of release %q: %w", i.ReleaseName, err)
+	}
+
+	// A release which is mid-upgrade or which failed is not considered installed
+	if status.Info.Status != "deployed" {
+		i.Logger.InfoContext(ctx, "helm release is not deployed", "release", i.ReleaseName, "status", status.Info.Status)
+		return "", "", nil
+	}
+
+	return status.Config.Image.Repository, status.Config.Image.Tag, nil
+}
@@ -1,31 +1,59 @@
 package install
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
+	"strings"
 	"time"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
 )
 
 type Installer interface {
-	Install(imageName, imageTag string, timeout time.Duration) error
+	Install(ctx context.Context, imageName, imageTag string, timeout time.Duration) error
+}
+
+// Uninstaller tears down what was installed. Uninstalling what is not installed is not an error.
+type Uninstaller interface {
+	Uninstall(ctx context.Context) error
 }
 
 type HelmK8sAtomicInstaller struct {
 	ReleaseName  string
 	K8sNamespace string
 	ChartPath    string
+	Logger       *slog.Logger
+
+	Environment string
+	// Owner is the installer which created this one for an environment, whereupon the
+	// environment's namespace is its own. May be nil, in which case the namespace is shared.
+	Owner *HelmK8sAtomicInstaller
 }
 
-func NewHelmK8sAtomicInstaller(releaseName, k8sNamespace, chartPath string) *HelmK8sAtomicInstaller {
+func NewHelmK8sAtomicInstaller(releaseName, k8sNamespace, chartPath string, logger *slog.Logger) *HelmK8sAtomicInstaller {
 	return &HelmK8sAtomicInstaller{
 		ReleaseName:  releaseName,
 		K8sNamespace: k8sNamespace,
 		ChartPath:    chartPath,
+		Logger:       logger,
 	}
 }
 
-func (i *HelmK8sAtomicInstaller) Install(imageName, imageTag string, timeout time.Duration) error {
+// ForEnvironment returns an installer of the same chart into the given release and namespace
+// of the environment. The namespace is created labelled as owned by this installer, and is
+// deleted upon uninstalling only if it was.
+func (i *HelmK8sAtomicInstaller) ForEnvironment(environment, releaseName, k8sNamespace string) *HelmK8sAtomicInstaller {
+	installer := NewHelmK8sAtomicInstaller(releaseName, k8sNamespace, i.ChartPath, i.Logger)
+	installer.Environment = environment
+	installer.Owner = i
+
+	return installer
+}
+
+func (i *HelmK8sAtomicInstaller) Install(ctx context.Context, imageName, imageTag string, timeout time.Duration) error {
 	/*
 		helm upgrade \
 			--install \
@@ -39,9 +67,16 @@ func (i *HelmK8sAtomicInstaller) Install(imageName, imageTag string, timeout tim
 			"$helm_chart_dir"
 	*/
 
-	log.Printf("installing Helm release %q in namespace %q", i.ReleaseName, i.K8sNamespace)
+	// Helm cannot label the namespace it creates, so the environment's namespace is created beforehand
+	if i.Owner != nil {
+		if err := i.createNamespace(ctx); err != nil {
+			return err
+		}
+	}
+
+	i.Logger.InfoContext(ctx, "installing helm release", "release", i.ReleaseName, "namespace", i.K8sNamespace)
 
-	cmd := exec.Command("helm",
+	cmd := executil.Command(ctx, "helm",
 		"upgrade",
 		"--install",
 		"--atomic",
@@ -53,12 +88,57 @@ func (i *HelmK8sAtomicInstaller) Install(imageName, imageTag string, timeout tim
 		i.ReleaseName,
 		i.ChartPath)
 
-	log.Printf("executing command: %s", cmd.String())
-	if err := cmd.Run(); err != nil {
-		// TODO: Capture stderr and use in error
+	i.Logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	if err := executil.Run(ctx, i.Logger, cmd); err != nil {
 		return fmt.Errorf("running helm upgrade command with chart %q: %w", i.ChartPath, err)
 	}
-	log.Println("helm release installed")
+	i.Logger.InfoContext(ctx, "helm release installed", "release", i.ReleaseName)
+
+	return nil
+}
+
+func (i *HelmK8sAtomicInstaller) Uninstall(ctx context.Context) error {
+	if err := i.uninstallRelease(ctx); err != nil {
+		return err
+	}
+
+	if i.Owner != nil {
+		return i.deleteNamespace(ctx)
+	}
+
+	return nil
+}
+
+func (i *HelmK8sAtomicInstaller) uninstallRelease(ctx context.Context) error {
+	/*
+		helm uninstall \
+			--wait \
+			-n "$k8s_namespace" \
+			"$release_name"
+	*/
+
+	i.Logger.InfoContext(ctx, "uninstalling helm release", "release", i.ReleaseName, "namespace", i.K8sNamespace)
+
+	cmd := executil.Command(ctx, "helm",
+		"uninstall",
+		"--wait",
+		"-n", i.K8sNamespace,
+		i.ReleaseName)
+
+	i.Logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	_, err := cmd.Output()
+	exitErr := new(exec.ExitError)
+	if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "not found") {
+		i.Logger.InfoContext(ctx, "helm release not found", "release", i.ReleaseName)
+		return nil
+	} else if errors.As(err, &exitErr) {
+		return fmt.Errorf("running helm uninstall command for release %q: %w",
+			i.ReleaseName,
+			&executil.Error{Err: err, StderrTail: []string{strings.TrimSpace(string(exitErr.Stderr))}})
+	} else if err != nil {
+		return fmt.Errorf("running helm uninstall command for release %q: %w", i.ReleaseName, err)
+	}
+	i.Logger.InfoContext(ctx, "helm release uninstalled", "release", i.ReleaseName)
 
 	return nil
 }
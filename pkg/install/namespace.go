@@ -0,0 +1,181 @@
+package install
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
+)
+
+// The labels and annotations of the namespaces created for environments. Only namespaces
+// labelled as owned by an installer are ever deleted or listed by it, so those which
+// existed beforehand are left alone.
+const (
+	managedByLabel        = "app.kubernetes.io/managed-by"
+	managedByValue        = "mockcicd"
+	ownerReleaseLabel     = "mockcicd/owner-release"
+	ownerNamespaceLabel   = "mockcicd/owner-namespace"
+	environmentAnnotation = "mockcicd/environment"
+	releaseAnnotation     = "mockcicd/release"
+)
+
+// k8sNamespace is the subset of a Kubernetes namespace that is of interest.
+type k8sNamespace struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name        string            `json:"name"`
+		Labels      map[string]string `json:"labels,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+}
+
+// k8sNamespaceList is the subset of the output of "kubectl get namespaces -o json" that is of interest.
+type k8sNamespaceList struct {
+	Items []*k8sNamespace `json:"items"`
+}
+
+// ownerSelector selects the namespaces owned by the installer.
+func (i *HelmK8sAtomicInstaller) ownerSelector() string {
+	return managedByLabel + "=" + managedByValue + "," +
+		ownerReleaseLabel + "=" + i.ReleaseName + "," +
+		ownerNamespaceLabel + "=" + i.K8sNamespace
+}
+
+// createNamespace creates the environment's namespace labelled as owned by the installer's
+// owner, unless it already exists.
+func (i *HelmK8sAtomicInstaller) createNamespace(ctx context.Context) error {
+	/*
+		kubectl create -f - <<EOF
+		{
+			"apiVersion": "v1",
+			"kind": "Namespace",
+			"metadata": {
+				"name": "$k8s_namespace",
+				"labels": {...},
+				"annotations": {...}
+			}
+		}
+		EOF
+	*/
+
+	namespace := &k8sNamespace{APIVersion: "v1", Kind: "Namespace"}
+	namespace.Metadata.Name = i.K8sNamespace
+	namespace.Metadata.Labels = map[string]string{
+		managedByLabel:      managedByValue,
+		ownerReleaseLabel:   i.Owner.ReleaseName,
+		ownerNamespaceLabel: i.Owner.K8sNamespace,
+	}
+	namespace.Metadata.Annotations = map[string]string{
+		environmentAnnotation: i.Environment,
+		releaseAnnotation:     i.ReleaseName,
+	}
+	manifest, err := json.Marshal(namespace)
+	if err != nil {
+		return fmt.Errorf("encoding kubernetes namespace %q: %w", i.K8sNamespace, err)
+	}
+
+	i.Logger.InfoContext(ctx, "creating kubernetes namespace", "namespace", i.K8sNamespace)
+
+	cmd := executil.Command(ctx, "kubectl", "create", "-f", "-")
+	cmd.Stdin = strings.NewReader(string(manifest))
+
+	i.Logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	_, err = cmd.Output()
+	exitErr := new(exec.ExitError)
+	if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "AlreadyExists") {
+		i.Logger.InfoContext(ctx, "kubernetes namespace already exists", "namespace", i.K8sNamespace)
+		return nil
+	} else if errors.As(err, &exitErr) {
+		return fmt.Errorf("running kubectl create command for namespace %q: %w",
+			i.K8sNamespace,
+			&executil.Error{Err: err, StderrTail: []string{strings.TrimSpace(string(exitErr.Stderr))}})
+	} else if err != nil {
+		return fmt.Errorf("running kubectl create command for namespace %q: %w", i.K8sNamespace, err)
+	}
+	i.Logger.InfoContext(ctx, "kubernetes namespace created", "namespace", i.K8sNamespace)
+
+	return nil
+}
+
+// deleteNamespace deletes the environment's namespace, which Helm does not do when
+// uninstalling the release, but only if it is labelled as owned by the installer's owner.
+func (i *HelmK8sAtomicInstaller) deleteNamespace(ctx context.Context) error {
+	/*
+		kubectl delete namespace \
+			--wait \
+			--ignore-not-found \
+			-l "$owner_selector" \
+			--field-selector metadata.name="$k8s_namespace"
+	*/
+
+	i.Logger.InfoContext(ctx, "deleting kubernetes namespace", "namespace", i.K8sNamespace)
+
+	cmd := executil.Command(ctx, "kubectl",
+		"delete",
+		"namespace",
+		"--wait",
+		"--ignore-not-found",
+		"-l", i.Owner.ownerSelector(),
+		"--field-selector", "metadata.name="+i.K8sNamespace)
+
+	i.Logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	if err := executil.Run(ctx, i.Logger, cmd); err != nil {
+		return fmt.Errorf("running kubectl delete command for namespace %q: %w", i.K8sNamespace, err)
+	}
+	i.Logger.InfoContext(ctx, "kubernetes namespace deleted", "namespace", i.K8sNamespace)
+
+	return nil
+}
+
+// Environments returns an installer for each environment whose namespace is owned by the
+// installer, by the name of the environment, including those whose release is not installed.
+func (i *HelmK8sAtomicInstaller) Environments(ctx context.Context) (map[string]*HelmK8sAtomicInstaller, error) {
+	/*
+		kubectl get namespaces \
+			-l "$owner_selector" \
+			-o json
+	*/
+
+	i.Logger.InfoContext(ctx, "listing kubernetes namespaces of environments", "release", i.ReleaseName)
+
+	cmd := executil.Command(ctx, "kubectl",
+		"get",
+		"namespaces",
+		"-l", i.ownerSelector(),
+		"-o", "json")
+
+	i.Logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	output, err := cmd.Output()
+	exitErr := new(exec.ExitError)
+	if errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("running kubectl get command for namespaces: %w",
+			&executil.Error{Err: err, StderrTail: []string{strings.TrimSpace(string(exitErr.Stderr))}})
+	} else if err != nil {
+		return nil, fmt.Errorf("running kubectl get command for namespaces: %w", err)
+	}
+
+	list := new(k8sNamespaceList)
+	if err := json.Unmarshal(output, list); err != nil {
+		return nil, fmt.Errorf("decoding kubernetes namespaces: %w", err)
+	}
+
+	environments := make(map[string]*HelmK8sAtomicInstaller, len(list.Items))
+	for _, namespace := range list.Items {
+		environment := namespace.Metadata.Annotations[environmentAnnotation]
+		releaseName := namespace.Metadata.Annotations[releaseAnnotation]
+		if environment == "" || releaseName == "" {
+			i.Logger.WarnContext(ctx, "kubernetes namespace of environment is missing annotations, skipping",
+				"namespace", namespace.Metadata.Name)
+			continue
+		}
+
+		environments[environment] = i.ForEnvironment(environment, releaseName, namespace.Metadata.Name)
+	}
+
+	return environments, nil
+}
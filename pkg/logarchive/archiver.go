@@ -0,0 +1,182 @@
+package logarchive
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timestampLayout is used to name run directories such that they sort chronologically.
+const timestampLayout = "20060102T150405Z"
+
+// Archiver archives the output of each pipeline run.
+type Archiver interface {
+	Open(commit string, started time.Time) (*RunLog, error)
+}
+
+// DirArchiver archives the output of each run to its own directory, named by the time the
+// run started and the commit being run. Old run directories are removed when a new one is
+// opened, so that at most MaxCount are retained and none are older than MaxAge.
+// A zero MaxCount or MaxAge disables that form of retention.
+type DirArchiver struct {
+	Path     string
+	MaxCount int
+	MaxAge   time.Duration
+	Logger   *slog.Logger
+}
+
+func NewDirArchiver(path string, maxCount int, maxAge time.Duration, logger *slog.Logger) *DirArchiver {
+	return &DirArchiver{
+		Path:     path,
+		MaxCount: maxCount,
+		MaxAge:   maxAge,
+		Logger:   logger,
+	}
+}
+
+func (a *DirArchiver) Open(commit string, started time.Time) (*RunLog, error) {
+	name := started.UTC().Format(timestampLayout)
+	if commit != "" {
+		name += "-" + commit
+	}
+	path := filepath.Join(a.Path, name)
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("creating run log directory %q: %w", path, err)
+	}
+
+	if err := a.prune(name); err != nil {
+		// Failure to prune old logs should not prevent the new run being logged
+		a.Logger.Warn("error pruning run log directories", "path", a.Path, "error", err)
+	}
+
+	return newRunLog(path), nil
+}
+
+// prune removes run directories beyond the retention limits, other than the current one.
+func (a *DirArchiver) prune(current string) error {
+	entries, err := os.ReadDir(a.Path)
+	if err != nil {
+		return fmt.Errorf("listing run log directories: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != current {
+			names = append(names, entry.Name())
+		}
+	}
+
+	// Newest first, so that those beyond the count limit are the oldest
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	for i, name := range names {
+		expired := false
+
+		// Allow for the current run when applying the count limit
+		if a.MaxCount > 0 && i+1 >= a.MaxCount {
+			expired = true
+		}
+
+		if a.MaxAge > 0 && !expired {
+			info, err := os.Stat(filepath.Join(a.Path, name))
+			if err != nil {
+				return fmt.Errorf("getting info of run log directory %q: %w", name, err)
+			}
+
+			expired = time.Since(info.ModTime()) > a.MaxAge
+		}
+
+		if !expired {
+			continue
+		}
+
+		a.Logger.Info("removing expired run log directory", "name", name)
+		if err := os.RemoveAll(filepath.Join(a.Path, name)); err != nil {
+			return fmt.Errorf("removing run log directory %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// RunLog is the archived output of a single pipeline run, with a file per stage.
+type RunLog struct {
+	Path string
+
+	mu    sync.Mutex
+	files []*os.File
+}
+
+func newRunLog(path string) *RunLog {
+	return &RunLog{
+		Path: path,
+	}
+}
+
+// Stage returns a writer for the output of the named stage.
+// The stage's file is only created once output is written to it, so that stages which
+// produce no output do not leave empty files.
+func (l *RunLog) Stage(name string) *StageLog {
+	return &StageLog{
+		runLog: l,
+		path:   filepath.Join(l.Path, name+".log"),
+	}
+}
+
+// Close closes the files of all stages.
+func (l *RunLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, file := range l.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("closing run log file %q: %w", file.Name(), err)
+		}
+	}
+	l.files = nil
+
+	return firstErr
+}
+
+func (l *RunLog) create(path string) (*os.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating run log file %q: %w", path, err)
+	}
+	l.files = append(l.files, file)
+
+	return file, nil
+}
+
+// StageLog is the archived output of a single stage of a pipeline run.
+type StageLog struct {
+	runLog *RunLog
+	path   string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (s *StageLog) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		file, err := s.runLog.create(s.path)
+		if err != nil {
+			return 0, err
+		}
+		s.file = file
+	}
+
+	return s.file.Write(p)
+}
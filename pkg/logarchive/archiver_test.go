@@ -0,0 +1,94 @@
+package logarchive
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestOpenRetainsAtMostMaxCountRuns(t *testing.T) {
+	dir := t.TempDir()
+	archiver := NewDirArchiver(dir, 3, 0, testLogger)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		runLog, err := archiver.Open("commit", start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+		}
+		runLog.Close()
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading archive directory: %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 run directories to be retained, got %d", len(entries))
+	}
+
+	// The oldest runs should be the ones removed
+	expectedOldest := "20210101T000200Z-commit"
+	if entries[0].Name() != expectedOldest {
+		t.Errorf("expected oldest retained run directory to be %q, got %q", expectedOldest, entries[0].Name())
+	}
+}
+
+func TestOpenRemovesRunsOlderThanMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	archiver := NewDirArchiver(dir, 0, time.Hour, testLogger)
+
+	expired := filepath.Join(dir, "20210101T000000Z-old")
+	if err := os.Mkdir(expired, 0700); err != nil {
+		t.Fatalf("creating expired run directory: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatalf("setting time of expired run directory: %v", err)
+	}
+
+	runLog, err := archiver.Open("new", time.Now())
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+	defer runLog.Close()
+
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expected expired run directory to be removed, but was not (error: %v)", err)
+	}
+	if _, err := os.Stat(runLog.Path); err != nil {
+		t.Errorf("expected new run directory to exist, got error %q", err)
+	}
+}
+
+func TestStageFileOnlyCreatedUponWrite(t *testing.T) {
+	runLog, err := NewDirArchiver(t.TempDir(), 0, 0, testLogger).Open("commit", time.Now())
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+	defer runLog.Close()
+
+	runLog.Stage("silent")
+	written := runLog.Stage("noisy")
+	if _, err := written.Write([]byte("output\n")); err != nil {
+		t.Fatalf("expected nil error writing stage log, got %q", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(runLog.Path, "silent.log")); !os.IsNotExist(err) {
+		t.Errorf("expected no file for stage without output, got error %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(runLog.Path, "noisy.log"))
+	if err != nil {
+		t.Fatalf("reading stage log: %v", err)
+	}
+	if string(content) != "output\n" {
+		t.Errorf("expected stage log content %q, got %q", "output\n", content)
+	}
+}
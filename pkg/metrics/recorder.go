@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "mockcicd"
+
+// Recorder records telemetry about the pipeline.
+type Recorder interface {
+	Checked(changed bool, err error)
+	Staged(stage string, duration time.Duration, err error)
+	Installed(commit string)
+	Deployed(commit string)
+}
+
+// PrometheusRecorder records metrics labelled with the environment deployed by the pipeline,
+// which is empty unless deploying preview environments.
+type PrometheusRecorder struct {
+	environment string
+
+	checks                 *prometheus.CounterVec
+	checkErrors            *prometheus.CounterVec
+	changes                *prometheus.CounterVec
+	stageDuration          *prometheus.HistogramVec
+	stageFailures          *prometheus.CounterVec
+	lastSuccessfulDeployAt *prometheus.GaugeVec
+	deployedCommit         *prometheus.GaugeVec
+}
+
+func NewPrometheusRecorder(registerer prometheus.Registerer) *PrometheusRecorder {
+	r := &PrometheusRecorder{
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "checks_total",
+			Help:      "Number of checks for changes performed.",
+		}, []string{"environment"}),
+		checkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "check_errors_total",
+			Help:      "Number of checks for changes which failed.",
+		}, []string{"environment"}),
+		changes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "changes_detected_total",
+			Help:      "Number of checks for changes which detected a change.",
+		}, []string{"environment"}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "stage_duration_seconds",
+			Help:      "Duration of each pipeline stage, whether successful or not.",
+			// Stages range from sub-second tag deduction to builds and installs taking many minutes
+			Buckets: prometheus.ExponentialBuckets(0.1, 3, 10),
+		}, []string{"environment", "stage"}),
+		stageFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stage_failures_total",
+			Help:      "Number of times each pipeline stage failed.",
+		}, []string{"environment", "stage"}),
+		lastSuccessfulDeployAt: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_successful_deploy_timestamp_seconds",
+			Help:      "Time of the last successful deploy, in seconds since the Unix epoch.",
+		}, []string{"environment"}),
+		deployedCommit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "deployed_commit_info",
+			Help:      "The commit currently deployed, as indicated by the commit label of the series with value 1.",
+		}, []string{"environment", "commit"}),
+	}
+
+	registerer.MustRegister(r.checks,
+		r.checkErrors,
+		r.changes,
+		r.stageDuration,
+		r.stageFailures,
+		r.lastSuccessfulDeployAt,
+		r.deployedCommit)
+	r.initCounters()
+
+	return r
+}
+
+// ForEnvironment returns a recorder of the same metrics, labelled with the given environment.
+func (r *PrometheusRecorder) ForEnvironment(environment string) *PrometheusRecorder {
+	forEnvironment := *r
+	forEnvironment.environment = environment
+	forEnvironment.initCounters()
+
+	return &forEnvironment
+}
+
+// RemoveEnvironment removes the series of the given environment, once it no longer exists.
+func (r *PrometheusRecorder) RemoveEnvironment(environment string) {
+	labels := prometheus.Labels{"environment": environment}
+	r.checks.DeletePartialMatch(labels)
+	r.checkErrors.DeletePartialMatch(labels)
+	r.changes.DeletePartialMatch(labels)
+	r.stageDuration.DeletePartialMatch(labels)
+	r.stageFailures.DeletePartialMatch(labels)
+	r.lastSuccessfulDeployAt.DeletePartialMatch(labels)
+	r.deployedCommit.DeletePartialMatch(labels)
+}
+
+// initCounters creates the series of the counters of the environment, so that they are
+// reported as zero before first being incremented.
+func (r *PrometheusRecorder) initCounters() {
+	r.checks.WithLabelValues(r.environment)
+	r.checkErrors.WithLabelValues(r.environment)
+	r.changes.WithLabelValues(r.environment)
+}
+
+func (r *PrometheusRecorder) Checked(changed bool, err error) {
+	r.checks.WithLabelValues(r.environment).Inc()
+
+	if err != nil {
+		r.checkErrors.WithLabelValues(r.environment).Inc()
+		return
+	}
+
+	if changed {
+		r.changes.WithLabelValues(r.environment).Inc()
+	}
+}
+
+func (r *PrometheusRecorder) Staged(stage string, duration time.Duration, err error) {
+	r.stageDuration.WithLabelValues(r.environment, stage).Observe(duration.Seconds())
+
+	if err != nil {
+		r.stageFailures.WithLabelValues(r.environment, stage).Inc()
+	}
+}
+
+// Installed records the commit found to be installed, without it having been deployed.
+func (r *PrometheusRecorder) Installed(commit string) {
+	// Only the current commit of the environment should have a series
+	r.deployedCommit.DeletePartialMatch(prometheus.Labels{"environment": r.environment})
+	r.deployedCommit.WithLabelValues(r.environment, commit).Set(1)
+}
+
+// Deployed records the commit having been successfully deployed.
+func (r *PrometheusRecorder) Deployed(commit string) {
+	r.Installed(commit)
+	r.lastSuccessfulDeployAt.WithLabelValues(r.environment).SetToCurrentTime()
+}
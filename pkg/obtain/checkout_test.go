@@ -0,0 +1,216 @@
+package obtain
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+	"github.com/jhwbarlow/mockcicd/pkg/prepare"
+)
+
+func newRemote(t *testing.T) (*git.Repository, string) {
+	t.Helper()
+
+	remotePath := t.TempDir()
+	remote, err := git.PlainInitWithOptions(remotePath, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: gitplumbing.NewBranchReferenceName("main")},
+	})
+	if err != nil {
+		t.Fatalf("initialising remote: %v", err)
+	}
+
+	for _, dir := range []string{"app", "docker", "other"} {
+		if err := os.Mkdir(filepath.Join(remotePath, dir), 0o755); err != nil {
+			t.Fatalf("creating directory: %v", err)
+		}
+	}
+
+	return remote, "file://" + remotePath
+}
+
+func assertExists(t *testing.T, path string, expected bool) {
+	t.Helper()
+
+	_, err := os.Stat(path)
+	if exists := err == nil; exists != expected {
+		t.Errorf("expected %q to exist: %t, got error %v", path, expected, err)
+	}
+}
+
+func TestPartialCloneAndPull(t *testing.T) {
+	remote, remoteURL := newRemote(t)
+	commitFile(t, remote, "app/main.go", "first")
+	commitFile(t, remote, "docker/Dockerfile", "first")
+	commitFile(t, remote, "other/huge", "first")
+
+	checkout := gitutil.CheckoutOptions{Depth: 1, SparsePaths: []string{"app", "docker"}}
+	destPath := filepath.Join(t.TempDir(), "src")
+	obtainer := NewGitCloneObtainer(remoteURL,
+		mainBranch,
+		checkout,
+		gitauth.NewAnonymousProvider(),
+		prepare.NewFilesystemPreparer(discardLogger),
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	assertExists(t, filepath.Join(destPath, "app", "main.go"), true)
+	assertExists(t, filepath.Join(destPath, "docker", "Dockerfile"), true)
+	assertExists(t, filepath.Join(destPath, "other", "huge"), false)
+
+	repo, err := git.PlainOpen(destPath)
+	if err != nil {
+		t.Fatalf("opening clone: %v", err)
+	}
+
+	shallow, err := repo.Storer.Shallow()
+	if err != nil {
+		t.Fatalf("reading shallow commits: %v", err)
+	}
+
+	if len(shallow) == 0 {
+		t.Error("expected clone to be shallow")
+	}
+
+	commitFile(t, remote, "app/new.go", "second")
+	expected := commitFile(t, remote, "other/huger", "second")
+
+	updater := NewGitPullUpdater(mainBranch, checkout, gitauth.NewAnonymousProvider(), discardLogger)
+	if err := updater.Update(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("getting HEAD: %v", err)
+	}
+
+	if head.Hash() != expected {
+		t.Errorf("expected HEAD %s, got %s", expected, head.Hash())
+	}
+
+	assertExists(t, filepath.Join(destPath, "app", "new.go"), true)
+	assertExists(t, filepath.Join(destPath, "other", "huger"), false)
+}
+
+func TestShallowCloneAndPull(t *testing.T) {
+	remote, remoteURL := newRemote(t)
+	commitFile(t, remote, "app/main.go", "first")
+	commitFile(t, remote, "app/main.go", "second")
+
+	checkout := gitutil.CheckoutOptions{Depth: 1}
+	destPath := filepath.Join(t.TempDir(), "src")
+	obtainer := NewGitCloneObtainer(remoteURL,
+		mainBranch,
+		checkout,
+		gitauth.NewAnonymousProvider(),
+		prepare.NewFilesystemPreparer(discardLogger),
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expected := commitFile(t, remote, "other/new", "third")
+
+	updater := NewGitPullUpdater(mainBranch, checkout, gitauth.NewAnonymousProvider(), discardLogger)
+	if err := updater.Update(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	repo, err := git.PlainOpen(destPath)
+	if err != nil {
+		t.Fatalf("opening clone: %v", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("getting HEAD: %v", err)
+	}
+
+	if head.Hash() != expected {
+		t.Errorf("expected HEAD %s, got %s", expected, head.Hash())
+	}
+
+	assertExists(t, filepath.Join(destPath, "other", "new"), true)
+}
+
+// runGit runs the git CLI, which is used to create submodules as go-git cannot.
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-c", "protocol.file.allow=always"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("running git %v: %v: %s", args, err, output)
+	}
+}
+
+func TestSubmoduleCloneAndPull(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git CLI not available")
+	}
+
+	sub, subURL := newRemote(t)
+	commitFile(t, sub, "asset", "first")
+
+	_, remoteURL := newRemote(t)
+	remotePath := strings.TrimPrefix(remoteURL, "file://")
+	runGit(t, remotePath, "submodule", "add", subURL, "assets")
+	runGit(t, remotePath, "commit", "-m", "add submodule")
+
+	checkout := gitutil.CheckoutOptions{Submodules: true}
+	destPath := filepath.Join(t.TempDir(), "src")
+	obtainer := NewGitCloneObtainer(remoteURL,
+		mainBranch,
+		checkout,
+		gitauth.NewAnonymousProvider(),
+		prepare.NewFilesystemPreparer(discardLogger),
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	assertExists(t, filepath.Join(destPath, "assets", "asset"), true)
+
+	// Bump the submodule pointer
+	commitFile(t, sub, "new-asset", "second")
+	runGit(t, filepath.Join(remotePath, "assets"), "pull", "origin", "main")
+	runGit(t, remotePath, "commit", "-am", "bump submodule")
+
+	updater := NewGitPullUpdater(mainBranch, checkout, gitauth.NewAnonymousProvider(), discardLogger)
+	if err := updater.Update(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	assertExists(t, filepath.Join(destPath, "assets", "new-asset"), true)
+
+	// Resetting the existing clone must not remove the submodule's files
+	fallback := new(recordingObtainer)
+	fetcher := NewGitFetchObtainer(remoteURL,
+		mainBranch,
+		checkout,
+		gitauth.NewAnonymousProvider(),
+		fallback,
+		discardLogger)
+	if err := fetcher.Obtain(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if fallback.obtained {
+		t.Error("expected existing clone to be reused, but fallback was used")
+	}
+
+	assertExists(t, filepath.Join(destPath, "assets", "new-asset"), true)
+}
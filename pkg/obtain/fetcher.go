@@ -0,0 +1,60 @@
+package obtain
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+)
+
+// GitFetchObtainer reuses an existing clone of the repository, fetching the selected ref
+// and hard-resetting to it. If there is no existing clone, or it is corrupt or a clone of
+// a different repository, the fallback obtainer is used instead.
+type GitFetchObtainer struct {
+	URL      string
+	Selector gitutil.RefSelector
+	Checkout gitutil.CheckoutOptions
+	Auth     gitauth.Provider
+	Fallback Obtainer
+	Logger   *slog.Logger
+}
+
+func NewGitFetchObtainer(URL string,
+	selector gitutil.RefSelector,
+	checkout gitutil.CheckoutOptions,
+	auth gitauth.Provider,
+	fallback Obtainer,
+	logger *slog.Logger) *GitFetchObtainer {
+	return &GitFetchObtainer{
+		URL:      URL,
+		Selector: selector,
+		Checkout: checkout,
+		Auth:     auth,
+		Fallback: fallback,
+		Logger:   logger,
+	}
+}
+
+func (o *GitFetchObtainer) Obtain(ctx context.Context, destPath string) error {
+	if err := gitutil.VerifyGitRepo(ctx, o.Logger, destPath, o.URL); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("verifying existing Git repo: %w", err)
+		}
+
+		o.Logger.WarnContext(ctx, "existing repository unusable, falling back", "error", err)
+		return o.Fallback.Obtain(ctx, destPath)
+	}
+
+	auth, err := o.Auth.AuthMethod(ctx)
+	if err != nil {
+		return fmt.Errorf("getting git authentication: %w", err)
+	}
+
+	if err := gitutil.FetchAndReset(ctx, o.Logger, auth, destPath, o.Selector, o.Checkout); err != nil {
+		return fmt.Errorf("fetching Git repo: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package obtain
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+)
+
+var (
+	discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	mainBranch    = gitutil.NewBranchSelector("main")
+)
+
+type recordingObtainer struct {
+	obtained bool
+}
+
+func (o *recordingObtainer) Obtain(ctx context.Context, destPath string) error {
+	o.obtained = true
+	return nil
+}
+
+func commitFile(t *testing.T, repo *git.Repository, name, contents string) gitplumbing.Hash {
+	t.Helper()
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("getting worktree: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(worktree.Filesystem.Root(), name), []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	if _, err := worktree.Add(name); err != nil {
+		t.Fatalf("adding file: %v", err)
+	}
+
+	hash, err := worktree.Commit("commit "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("committing: %v", err)
+	}
+
+	return hash
+}
+
+// newRemoteAndClone returns a repository acting as the remote, and the path of a clone of it.
+func newRemoteAndClone(t *testing.T) (*git.Repository, string, string) {
+	t.Helper()
+
+	remotePath := t.TempDir()
+	remote, err := git.PlainInitWithOptions(remotePath, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: gitplumbing.NewBranchReferenceName("main")},
+	})
+	if err != nil {
+		t.Fatalf("initialising remote: %v", err)
+	}
+	commitFile(t, remote, "first", "first")
+
+	clonePath := t.TempDir()
+	if _, err := git.PlainClone(clonePath, false, &git.CloneOptions{URL: remotePath}); err != nil {
+		t.Fatalf("cloning remote: %v", err)
+	}
+
+	return remote, remotePath, clonePath
+}
+
+func TestGitFetchObtainerResetsExistingClone(t *testing.T) {
+	remote, remotePath, clonePath := newRemoteAndClone(t)
+	expected := commitFile(t, remote, "second", "second")
+
+	if err := os.WriteFile(filepath.Join(clonePath, "untracked"), nil, 0o644); err != nil {
+		t.Fatalf("writing untracked file: %v", err)
+	}
+
+	fallback := new(recordingObtainer)
+	obtainer := NewGitFetchObtainer(remotePath, mainBranch, gitutil.CheckoutOptions{}, gitauth.NewAnonymousProvider(), fallback, discardLogger)
+	if err := obtainer.Obtain(context.Background(), clonePath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if fallback.obtained {
+		t.Error("expected existing clone to be reused, but fallback was used")
+	}
+
+	repo, err := git.PlainOpen(clonePath)
+	if err != nil {
+		t.Fatalf("opening clone: %v", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("getting HEAD: %v", err)
+	}
+
+	if head.Hash() != expected {
+		t.Errorf("expected HEAD %s, got %s", expected, head.Hash())
+	}
+
+	if _, err := os.Stat(filepath.Join(clonePath, "second")); err != nil {
+		t.Errorf("expected fetched file to be checked out: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(clonePath, "untracked")); !os.IsNotExist(err) {
+		t.Errorf("expected untracked file to be removed, got %v", err)
+	}
+}
+
+func TestGitFetchObtainerFallsBackForOtherOrigin(t *testing.T) {
+	_, _, clonePath := newRemoteAndClone(t)
+
+	fallback := new(recordingObtainer)
+	obtainer := NewGitFetchObtainer("https://git.example.com/other.git",
+		mainBranch,
+		gitutil.CheckoutOptions{},
+		gitauth.NewAnonymousProvider(),
+		fallback,
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), clonePath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !fallback.obtained {
+		t.Error("expected fallback to be used for clone of another repository")
+	}
+}
+
+func TestGitFetchObtainerFallsBackForCorruptClone(t *testing.T) {
+	_, remotePath, clonePath := newRemoteAndClone(t)
+
+	// Remove the objects, leaving the references dangling
+	if err := os.RemoveAll(filepath.Join(clonePath, ".git", "objects")); err != nil {
+		t.Fatalf("removing objects: %v", err)
+	}
+
+	fallback := new(recordingObtainer)
+	obtainer := NewGitFetchObtainer(remotePath, mainBranch, gitutil.CheckoutOptions{}, gitauth.NewAnonymousProvider(), fallback, discardLogger)
+	if err := obtainer.Obtain(context.Background(), clonePath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !fallback.obtained {
+		t.Error("expected fallback to be used for corrupt clone")
+	}
+}
+
+func TestGitFetchObtainerFallsBackWithoutClone(t *testing.T) {
+	fallback := new(recordingObtainer)
+	obtainer := NewGitFetchObtainer("https://git.example.com/repo.git",
+		mainBranch,
+		gitutil.CheckoutOptions{},
+		gitauth.NewAnonymousProvider(),
+		fallback,
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), t.TempDir()); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if !fallback.obtained {
+		t.Error("expected fallback to be used when there is no existing clone")
+	}
+}
@@ -1,36 +1,61 @@
 package obtain
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 
 	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
 	"github.com/jhwbarlow/mockcicd/pkg/prepare"
 )
 
+// Modes in which the source code can be obtained at startup.
+const (
+	ModeClone = "clone"
+	ModeFetch = "fetch"
+)
+
 type Obtainer interface {
-	Obtain(destPath string) error
+	Obtain(ctx context.Context, destPath string) error
 }
 
 type GitCloneObtainer struct {
 	URL      string
-	Branch   string
+	Selector gitutil.RefSelector
+	Checkout gitutil.CheckoutOptions
+	Auth     gitauth.Provider
 	Preparer prepare.Preparer
+	Logger   *slog.Logger
 }
 
-func NewGitCloneObtainer(URL, branch string, preparer prepare.Preparer) *GitCloneObtainer {
+func NewGitCloneObtainer(URL string,
+	selector gitutil.RefSelector,
+	checkout gitutil.CheckoutOptions,
+	auth gitauth.Provider,
+	preparer prepare.Preparer,
+	logger *slog.Logger) *GitCloneObtainer {
 	return &GitCloneObtainer{
 		URL:      URL,
-		Branch:   branch,
+		Selector: selector,
+		Checkout: checkout,
+		Auth:     auth,
 		Preparer: preparer,
+		Logger:   logger,
 	}
 }
 
-func (o *GitCloneObtainer) Obtain(destPath string) error {
+func (o *GitCloneObtainer) Obtain(ctx context.Context, destPath string) error {
 	if err := o.Preparer.Prepare(destPath); err != nil {
 		return fmt.Errorf("preparing filesystem: %w", err)
 	}
 
-	if err := gitutil.CloneGitRepo(o.URL, o.Branch, destPath); err != nil {
+	auth, err := o.Auth.AuthMethod(ctx)
+	if err != nil {
+		return fmt.Errorf("getting git authentication: %w", err)
+	}
+
+	if err := gitutil.CloneGitRepo(ctx, o.Logger, auth, o.URL, o.Selector, destPath, o.Checkout); err != nil {
 		return fmt.Errorf("cloning Git repo: %w", err)
 	}
 
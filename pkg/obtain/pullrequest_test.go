@@ -0,0 +1,56 @@
+package obtain
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+	"github.com/jhwbarlow/mockcicd/pkg/prepare"
+)
+
+func pullRequest(t *testing.T, repo *git.Repository, name gitplumbing.ReferenceName, hash gitplumbing.Hash) {
+	t.Helper()
+
+	if err := repo.Storer.SetReference(gitplumbing.NewHashReference(name, hash)); err != nil {
+		t.Fatalf("setting pull request ref: %v", err)
+	}
+}
+
+func TestPullRequestCloneAndUpdate(t *testing.T) {
+	const name = gitplumbing.ReferenceName("refs/pull/7/head")
+
+	remote, remoteURL := newRemote(t)
+	first := commitFile(t, remote, "app/main.go", "pull request")
+	pullRequest(t, remote, name, first)
+	// Commits to the default branch are not deployed
+	commitFile(t, remote, "app/main.go", "main")
+
+	selector := gitutil.NewRefNameSelector(name)
+	destPath := filepath.Join(t.TempDir(), "src")
+	obtainer := NewGitCloneObtainer(remoteURL,
+		selector,
+		gitutil.CheckoutOptions{},
+		gitauth.NewAnonymousProvider(),
+		prepare.NewFilesystemPreparer(discardLogger),
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	assertHead(t, destPath, first)
+
+	second := commitFile(t, remote, "app/main.go", "pull request updated")
+	pullRequest(t, remote, name, second)
+
+	updater := NewGitPullUpdater(selector, gitutil.CheckoutOptions{}, gitauth.NewAnonymousProvider(), discardLogger)
+	if err := updater.Update(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	assertHead(t, destPath, second)
+}
@@ -0,0 +1,97 @@
+package obtain
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+	"github.com/jhwbarlow/mockcicd/pkg/prepare"
+)
+
+func tag(t *testing.T, repo *git.Repository, name string, hash gitplumbing.Hash, annotated bool) {
+	t.Helper()
+
+	var opts *git.CreateTagOptions
+	if annotated {
+		opts = &git.CreateTagOptions{
+			Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+			Message: name,
+		}
+	}
+
+	if _, err := repo.CreateTag(name, hash, opts); err != nil {
+		t.Fatalf("creating tag: %v", err)
+	}
+}
+
+func assertHead(t *testing.T, path string, expected gitplumbing.Hash) {
+	t.Helper()
+
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatalf("opening clone: %v", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("getting HEAD: %v", err)
+	}
+
+	if head.Hash() != expected {
+		t.Errorf("expected HEAD %s, got %s", expected, head.Hash())
+	}
+}
+
+func TestTagTrackingCloneAndUpdate(t *testing.T) {
+	remote, remoteURL := newRemote(t)
+	first := commitFile(t, remote, "app/main.go", "first")
+	tag(t, remote, "v1.0.0", first, true)
+	second := commitFile(t, remote, "app/main.go", "second")
+	tag(t, remote, "v1.1.0", second, false)
+	// Commits beyond the highest tag are not deployed
+	commitFile(t, remote, "app/main.go", "untagged")
+
+	selector, err := gitutil.NewTagSelector("v*", "")
+	if err != nil {
+		t.Fatalf("creating selector: %v", err)
+	}
+
+	destPath := filepath.Join(t.TempDir(), "src")
+	obtainer := NewGitCloneObtainer(remoteURL,
+		selector,
+		gitutil.CheckoutOptions{},
+		gitauth.NewAnonymousProvider(),
+		prepare.NewFilesystemPreparer(discardLogger),
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	assertHead(t, destPath, second)
+
+	third := commitFile(t, remote, "app/main.go", "third")
+	tag(t, remote, "v1.2.0", third, true)
+
+	updater := NewGitPullUpdater(selector, gitutil.CheckoutOptions{}, gitauth.NewAnonymousProvider(), discardLogger)
+	if err := updater.Update(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	assertHead(t, destPath, third)
+
+	tags, err := gitutil.HeadTags(destPath)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if len(tags) != 1 || tags[0].Name().Short() != "v1.2.0" {
+		t.Errorf("expected HEAD to be tagged v1.2.0, got %v", tags)
+	}
+}
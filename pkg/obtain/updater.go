@@ -1,29 +1,86 @@
 package obtain
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 
 	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+)
+
+// Strategies by which changes are obtained.
+const (
+	StrategyPull  = "pull"
+	StrategyReset = "reset"
 )
 
 type Updater interface {
-	Update(path string) error
+	Update(ctx context.Context, path string) error
 }
 
 type GitPullUpdater struct {
-	Branch string
+	Selector gitutil.RefSelector
+	Checkout gitutil.CheckoutOptions
+	Auth     gitauth.Provider
+	Logger   *slog.Logger
 }
 
-func NewGitPullUpdater(branch string) *GitPullUpdater {
+func NewGitPullUpdater(selector gitutil.RefSelector,
+	checkout gitutil.CheckoutOptions,
+	auth gitauth.Provider,
+	logger *slog.Logger) *GitPullUpdater {
 	return &GitPullUpdater{
-		Branch: branch,
+		Selector: selector,
+		Checkout: checkout,
+		Auth:     auth,
+		Logger:   logger,
 	}
 }
 
-func (u *GitPullUpdater) Update(path string) error {
-	if err := gitutil.PullGitRepo(path, u.Branch); err != nil {
+func (u *GitPullUpdater) Update(ctx context.Context, path string) error {
+	auth, err := u.Auth.AuthMethod(ctx)
+	if err != nil {
+		return fmt.Errorf("getting git authentication: %w", err)
+	}
+
+	if err := gitutil.PullGitRepo(ctx, u.Logger, auth, path, u.Selector, u.Checkout); err != nil {
 		return fmt.Errorf("pulling Git repo: %w", err)
 	}
 
 	return nil
 }
+
+// GitResetUpdater fetches the selected ref and hard-resets the worktree to it, discarding
+// any local modifications. Unlike pulling, this succeeds if the branch was force-pushed.
+type GitResetUpdater struct {
+	Selector gitutil.RefSelector
+	Checkout gitutil.CheckoutOptions
+	Auth     gitauth.Provider
+	Logger   *slog.Logger
+}
+
+func NewGitResetUpdater(selector gitutil.RefSelector,
+	checkout gitutil.CheckoutOptions,
+	auth gitauth.Provider,
+	logger *slog.Logger) *GitResetUpdater {
+	return &GitResetUpdater{
+		Selector: selector,
+		Checkout: checkout,
+		Auth:     auth,
+		Logger:   logger,
+	}
+}
+
+func (u *GitResetUpdater) Update(ctx context.Context, path string) error {
+	auth, err := u.Auth.AuthMethod(ctx)
+	if err != nil {
+		return fmt.Errorf("getting git authentication: %w", err)
+	}
+
+	if err := gitutil.FetchAndReset(ctx, u.Logger, auth, path, u.Selector, u.Checkout); err != nil {
+		return fmt.Errorf("fetching Git repo: %w", err)
+	}
+
+	return nil
+}
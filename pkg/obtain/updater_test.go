@@ -0,0 +1,70 @@
+package obtain
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+	"github.com/jhwbarlow/mockcicd/pkg/prepare"
+)
+
+func TestGitResetUpdaterHandlesForcePush(t *testing.T) {
+	remote, remoteURL := newRemote(t)
+	base := commitFile(t, remote, "app/main.go", "first")
+	commitFile(t, remote, "app/main.go", "second")
+
+	destPath := filepath.Join(t.TempDir(), "src")
+	obtainer := NewGitCloneObtainer(remoteURL,
+		mainBranch,
+		gitutil.CheckoutOptions{},
+		gitauth.NewAnonymousProvider(),
+		prepare.NewFilesystemPreparer(discardLogger),
+		discardLogger)
+	if err := obtainer.Obtain(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	// Rewrite the remote branch, as if force-pushed
+	worktree, err := remote.Worktree()
+	if err != nil {
+		t.Fatalf("getting worktree: %v", err)
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: base, Mode: git.HardReset}); err != nil {
+		t.Fatalf("resetting remote: %v", err)
+	}
+	expected := commitFile(t, remote, "app/main.go", "rewritten")
+
+	pullUpdater := NewGitPullUpdater(mainBranch, gitutil.CheckoutOptions{}, gitauth.NewAnonymousProvider(), discardLogger)
+	if err := pullUpdater.Update(context.Background(), destPath); err == nil {
+		t.Fatal("expected pull to fail after force-push, got nil error")
+	}
+
+	resetUpdater := NewGitResetUpdater(mainBranch, gitutil.CheckoutOptions{}, gitauth.NewAnonymousProvider(), discardLogger)
+	if err := resetUpdater.Update(context.Background(), destPath); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	repo, err := git.PlainOpen(destPath)
+	if err != nil {
+		t.Fatalf("opening clone: %v", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("getting HEAD: %v", err)
+	}
+
+	if head.Hash() != expected {
+		t.Errorf("expected HEAD %s, got %s", expected, head.Hash())
+	}
+
+	if head.Name() != gitplumbing.NewBranchReferenceName("main") {
+		t.Errorf("expected branch to be checked out, got %s", head.Name())
+	}
+}
@@ -2,7 +2,7 @@ package prepare
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 )
 
@@ -10,16 +10,20 @@ type Preparer interface {
 	Prepare(path string) error
 }
 
-type FilesystemPreparer struct{}
+type FilesystemPreparer struct {
+	Logger *slog.Logger
+}
 
-func NewFilesystemPreparer() *FilesystemPreparer {
-	return new(FilesystemPreparer)
+func NewFilesystemPreparer(logger *slog.Logger) *FilesystemPreparer {
+	return &FilesystemPreparer{
+		Logger: logger,
+	}
 }
 
 func (p *FilesystemPreparer) Prepare(path string) error {
 	_, err := os.Stat(path)
 	if err != nil && os.IsNotExist(err) {
-		log.Printf("creating source directory %q", path)
+		p.Logger.Info("creating source directory", "path", path)
 		if err := createDir(path); err != nil {
 			return fmt.Errorf("creating source directory %q: %w", path, err)
 		}
@@ -30,7 +34,7 @@ func (p *FilesystemPreparer) Prepare(path string) error {
 	}
 
 	// Src dir already exists, so empty it
-	log.Printf("emptying source directory %q", path)
+	p.Logger.Info("emptying source directory", "path", path)
 	if err := os.RemoveAll(path); err != nil {
 		return fmt.Errorf("emptying source directory %q: %w", path, err)
 	}
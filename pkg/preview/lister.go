@@ -0,0 +1,49 @@
+package preview
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/gitauth"
+)
+
+// Lister lists the refs for which preview environments are deployed.
+type Lister interface {
+	List(ctx context.Context) (gitutil.Refs, error)
+}
+
+// GitRemoteLister lists the refs of the remote repository matched by the matcher. The
+// heads of pull requests are only listed while the pull request is open.
+type GitRemoteLister struct {
+	URL     string
+	Matcher gitutil.RefMatcher
+	Auth    gitauth.Provider
+	Logger  *slog.Logger
+}
+
+func NewGitRemoteLister(URL string, matcher gitutil.RefMatcher, auth gitauth.Provider, logger *slog.Logger) *GitRemoteLister {
+	return &GitRemoteLister{
+		URL:     URL,
+		Matcher: matcher,
+		Auth:    auth,
+		Logger:  logger,
+	}
+}
+
+func (l *GitRemoteLister) List(ctx context.Context) (gitutil.Refs, error) {
+	auth, err := l.Auth.AuthMethod(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting git authentication: %w", err)
+	}
+
+	// Merge refs are listed alongside to determine which pull requests are open
+	matcher := gitutil.AnyRefMatcher{l.Matcher, gitutil.NewPullRequestMergeMatcher()}
+	refs, err := gitutil.ListRemoteRefs(ctx, l.Logger, auth, l.URL, matcher)
+	if err != nil {
+		return nil, fmt.Errorf("listing remote refs: %w", err)
+	}
+
+	return gitutil.OpenPullRequests(refs), nil
+}
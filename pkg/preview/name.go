@@ -0,0 +1,47 @@
+package preview
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+)
+
+// maxNameLength is the longest name of a Helm release. Kubernetes namespaces may be
+// longer, but the same limit is used for both so that they can share a name.
+const maxNameLength = 53
+
+// invalidNameChars are those which may not appear in a Helm release or Kubernetes
+// namespace name, which must be a DNS label.
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Environment returns the name of the preview environment of the ref, which is "pr-" and
+// the number of a pull request, or otherwise the name of the branch.
+func Environment(ref gitplumbing.ReferenceName) string {
+	if number, ok := gitutil.PullRequestNumber(ref); ok {
+		return "pr-" + strconv.Itoa(number)
+	}
+
+	return ref.Short()
+}
+
+// Name returns the name of the Helm release or Kubernetes namespace of the preview
+// environment, formed by suffixing the base name with the environment's name.
+// Names too long to be valid are truncated and made unique by a hash of the environment's name.
+func Name(base, environment string) string {
+	slug := invalidNameChars.ReplaceAllString(strings.ToLower(environment), "-")
+	name := strings.Trim(base+"-"+slug, "-")
+	if len(name) <= maxNameLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(environment))
+	suffix := "-" + hex.EncodeToString(sum[:])[:8]
+
+	return strings.TrimRight(name[:maxNameLength-len(suffix)], "-") + suffix
+}
@@ -0,0 +1,49 @@
+package preview
+
+import (
+	"strings"
+	"testing"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestEnvironment(t *testing.T) {
+	cases := map[gitplumbing.ReferenceName]string{
+		"refs/heads/feature/login":   "feature/login",
+		"refs/pull/12/head":          "pr-12",
+		"refs/merge-requests/3/head": "pr-3",
+	}
+
+	for ref, expected := range cases {
+		if environment := Environment(ref); environment != expected {
+			t.Errorf("expected %q for %q, got %q", expected, ref, environment)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	cases := map[string]string{
+		"feature/login":        "app-feature-login",
+		"Feature/JIRA-123_fix": "app-feature-jira-123-fix",
+		"feature/trailing.":    "app-feature-trailing",
+	}
+
+	for branch, expected := range cases {
+		if name := Name("app", branch); name != expected {
+			t.Errorf("expected %q for %q, got %q", expected, branch, name)
+		}
+	}
+}
+
+func TestNameTruncatesLongNames(t *testing.T) {
+	long := "feature/" + strings.Repeat("a", 60)
+
+	name := Name("app", long)
+	if len(name) != maxNameLength {
+		t.Errorf("expected name of length %d, got %q", maxNameLength, name)
+	}
+
+	if other := Name("app", long+"b"); other == name {
+		t.Errorf("expected distinct names for distinct long branches, got %q", name)
+	}
+}
@@ -0,0 +1,22 @@
+package push
+
+import (
+	"context"
+	"log/slog"
+)
+
+// BuildKitPusher pushes nothing, as images built by BuildKit are pushed as part of the build.
+type BuildKitPusher struct {
+	Logger *slog.Logger
+}
+
+func NewBuildKitPusher(logger *slog.Logger) *BuildKitPusher {
+	return &BuildKitPusher{
+		Logger: logger,
+	}
+}
+
+func (p *BuildKitPusher) Push(ctx context.Context, name, tag string) error {
+	p.Logger.InfoContext(ctx, "image already pushed by build", "image", name+":"+tag)
+	return nil
+}
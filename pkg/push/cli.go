@@ -0,0 +1,36 @@
+package push
+
+import (
+	"context"
+	"log/slog"
+)
+
+// CLIPusher pushes images by using an external CLI binary whose push command is compatible
+// with that of docker, such as podman or buildah.
+type CLIPusher struct {
+	Command string
+	Logger  *slog.Logger
+}
+
+func NewCLIPusher(command string, logger *slog.Logger) *CLIPusher {
+	return &CLIPusher{
+		Command: command,
+		Logger:  logger,
+	}
+}
+
+func (p *CLIPusher) Push(ctx context.Context, name, tag string) error {
+	/*
+		$command push "${image_name}:${image_tag}"
+	*/
+
+	fullImageName := name + ":" + tag
+	p.Logger.InfoContext(ctx, "pushing image", "command", p.Command, "image", fullImageName)
+
+	if err := runPushCommand(ctx, p.Logger, p.Command, fullImageName); err != nil {
+		return err
+	}
+	p.Logger.InfoContext(ctx, "image pushed", "image", fullImageName)
+
+	return nil
+}
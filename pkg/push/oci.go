@@ -0,0 +1,51 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/jhwbarlow/mockcicd/pkg/build"
+)
+
+// OCIPusher pushes images built by the OCIBuilder from its OCI layout to the registry,
+// authenticating with the credentials of the Docker config, if any, without a daemon.
+type OCIPusher struct {
+	Layout *build.OCILayout
+	Logger *slog.Logger
+}
+
+func NewOCIPusher(layout *build.OCILayout, logger *slog.Logger) *OCIPusher {
+	return &OCIPusher{
+		Layout: layout,
+		Logger: logger,
+	}
+}
+
+func (p *OCIPusher) Push(ctx context.Context, imageName, tag string) error {
+	fullImageName := imageName + ":" + tag
+	p.Logger.InfoContext(ctx, "pushing OCI image", "image", fullImageName)
+
+	ref, err := name.NewTag(fullImageName)
+	if err != nil {
+		return fmt.Errorf("parsing image name %q: %w", fullImageName, err)
+	}
+
+	index, err := p.Layout.Read(fullImageName)
+	if err != nil {
+		return err
+	}
+
+	if err := remote.WriteIndex(ref, index,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+		return fmt.Errorf("pushing image %q: %w", fullImageName, err)
+	}
+	p.Logger.InfoContext(ctx, "image pushed", "image", fullImageName)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package push
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/jhwbarlow/mockcicd/pkg/build"
+)
+
+func TestOCIPusherPush(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	layout, err := build.NewOCILayout(filepath.Join(t.TempDir(), "layout"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	index, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	imageName := strings.TrimPrefix(server.URL, "http://") + "/app"
+	if err := layout.Write(imageName+":v1.0.0", index); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	p := NewOCIPusher(layout, discardLogger)
+	if err := p.Push(context.Background(), imageName, "v1.0.0"); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	ref, err := name.NewTag(imageName + ":v1.0.0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	pushed, err := remote.Index(ref)
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	expectedDigest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	pushedDigest, err := pushed.Digest()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	if pushedDigest != expectedDigest {
+		t.Errorf("expected digest %s, got %s", expectedDigest, pushedDigest)
+	}
+}
+
+func TestOCIPusherPushNotBuilt(t *testing.T) {
+	layout, err := build.NewOCILayout(filepath.Join(t.TempDir(), "layout"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	p := NewOCIPusher(layout, discardLogger)
+	if err := p.Push(context.Background(), "registry.example.com/app", "v1.0.0"); err == nil {
+		t.Error("expected error pushing image not built, got nil")
+	}
+}
@@ -1,37 +1,51 @@
 package push
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os/exec"
+	"log/slog"
+
+	executil "github.com/jhwbarlow/mockcicd/pkg/exec"
 )
 
 type Pusher interface {
-	Push(name, tag string) error
+	Push(ctx context.Context, name, tag string) error
 }
 
-type DockerCLIPusher struct{}
+type DockerCLIPusher struct {
+	Logger *slog.Logger
+}
 
-func NewDockerCLIPusher() *DockerCLIPusher {
-	return new(DockerCLIPusher)
+func NewDockerCLIPusher(logger *slog.Logger) *DockerCLIPusher {
+	return &DockerCLIPusher{
+		Logger: logger,
+	}
 }
 
-func (*DockerCLIPusher) Push(name, tag string) error {
+func (p *DockerCLIPusher) Push(ctx context.Context, name, tag string) error {
 	/*
 		docker push "${image_name}:${image_tag}"
 	*/
 
 	fullImageName := name + ":" + tag
-	log.Printf("pushing docker image %q", fullImageName)
+	p.Logger.InfoContext(ctx, "pushing docker image", "image", fullImageName)
+
+	if err := runPushCommand(ctx, p.Logger, "docker", fullImageName); err != nil {
+		return err
+	}
+	p.Logger.InfoContext(ctx, "image pushed", "image", fullImageName)
+
+	return nil
+}
 
-	cmd := exec.Command("docker", "push", fullImageName)
+// runPushCommand runs the push command of the CLI, logging its output.
+func runPushCommand(ctx context.Context, logger *slog.Logger, name, fullImageName string) error {
+	cmd := executil.Command(ctx, name, "push", fullImageName)
 
-	log.Printf("executing command: %s", cmd.String())
-	if err := cmd.Run(); err != nil {
-		// TODO: Capture stderr and use in error
-		return fmt.Errorf("running docker push command for image %q: %w", fullImageName, err)
+	logger.InfoContext(ctx, "executing command", "command", cmd.String())
+	if err := executil.Run(ctx, logger, cmd); err != nil {
+		return fmt.Errorf("running %s push command for image %q: %w", name, fullImageName, err)
 	}
-	log.Println("image pushed")
 
 	return nil
 }
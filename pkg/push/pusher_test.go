@@ -0,0 +1,81 @@
+package push
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestCLIPushersRunCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		pusher  Pusher
+	}{
+		{"docker", NewDockerCLIPusher(discardLogger)},
+		{"podman", NewCLIPusher("podman", discardLogger)},
+		{"buildah", NewCLIPusher("buildah", discardLogger)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			argsPath := fakeExecutable(t, test.command, "exit 0")
+
+			if err := test.pusher.Push(context.Background(), "registry.example.com/app", "v1.0.0"); err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			content, err := os.ReadFile(argsPath)
+			if err != nil {
+				t.Fatalf("expected nil error, got %v", err)
+			}
+
+			args := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+			expected := []string{"push", "registry.example.com/app:v1.0.0"}
+			if !slices.Equal(args, expected) {
+				t.Errorf("expected args %q, got %q", expected, args)
+			}
+		})
+	}
+}
+
+func TestCLIPusherCommandFails(t *testing.T) {
+	fakeExecutable(t, "buildah", "echo 'unauthorized' >&2; exit 125")
+
+	err := NewCLIPusher("buildah", discardLogger).Push(context.Background(), "registry.example.com/app", "v1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("expected error including stderr, got %v", err)
+	}
+}
+
+func TestBuildKitPusherPushesNothing(t *testing.T) {
+	// Running buildctl at all would fail
+	fakeExecutable(t, "buildctl", "exit 1")
+
+	if err := NewBuildKitPusher(discardLogger).Push(context.Background(), "registry.example.com/app", "v1.0.0"); err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+}
+
+// fakeExecutable puts an executable of the name first on the PATH, which records its
+// arguments, one per line, in the returned file and then runs the script.
+func fakeExecutable(t *testing.T, name, script string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, name+".args")
+	content := "#!/bin/sh\nprintf '%s\\n' \"$@\" > '" + argsPath + "'\n" + script + "\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o755); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return argsPath
+}
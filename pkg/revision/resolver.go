@@ -0,0 +1,49 @@
+package revision
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+)
+
+// Resolver resolves the revision of the source code currently checked out.
+type Resolver interface {
+	Resolve(ctx context.Context) (string, error)
+}
+
+// AncestryChecker determines the relationship between revisions.
+type AncestryChecker interface {
+	IsAncestor(ctx context.Context, ancestor, descendant string) (bool, error)
+}
+
+type GitHeadResolver struct {
+	Path   string
+	Logger *slog.Logger
+}
+
+func NewGitHeadResolver(path string, logger *slog.Logger) *GitHeadResolver {
+	return &GitHeadResolver{
+		Path:   path,
+		Logger: logger,
+	}
+}
+
+func (r *GitHeadResolver) Resolve(ctx context.Context) (string, error) {
+	hash, err := gitutil.GetLocalGitHeadHash(ctx, r.Logger, r.Path)
+	if err != nil {
+		return "", fmt.Errorf("getting local git hash: %w", err)
+	}
+
+	return hash.String(), nil
+}
+
+func (r *GitHeadResolver) IsAncestor(ctx context.Context, ancestor, descendant string) (bool, error) {
+	isAncestor, err := gitutil.IsAncestor(ctx, r.Path, ancestor, descendant)
+	if err != nil {
+		return false, fmt.Errorf("checking git ancestry: %w", err)
+	}
+
+	return isAncestor, nil
+}
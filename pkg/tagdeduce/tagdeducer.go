@@ -1,30 +1,94 @@
 package tagdeduce
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"regexp"
 
 	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
 )
 
 type TagDeducer interface {
-	Deduce() (string, error)
+	Deduce(ctx context.Context) (string, error)
 }
 
 type GitHashTagDeducer struct {
-	Path string
+	Path   string
+	Logger *slog.Logger
 }
 
-func NewGitHashTagDeducer(path string) *GitHashTagDeducer {
+func NewGitHashTagDeducer(path string, logger *slog.Logger) *GitHashTagDeducer {
 	return &GitHashTagDeducer{
-		Path: path,
+		Path:   path,
+		Logger: logger,
 	}
 }
 
-func (d *GitHashTagDeducer) Deduce() (string, error) {
-	hash, err := gitutil.GetLocalGitHeadHash(d.Path)
+func (d *GitHashTagDeducer) Deduce(ctx context.Context) (string, error) {
+	hash, err := gitutil.GetLocalGitHeadHash(ctx, d.Logger, d.Path)
 	if err != nil {
 		return "", fmt.Errorf("getting local git hash: %w", err)
 	}
 
 	return hash.String(), nil
 }
+
+// invalidImageTagChars matches the characters which may not appear in a container image
+// tag, such as the "+" of semantic version build metadata.
+var invalidImageTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// GitTagTagDeducer deduces the image tag from the Git tag checked out, i.e. the tag
+// selected by the selector from those pointing to the HEAD commit.
+type GitTagTagDeducer struct {
+	Path     string
+	Selector gitutil.RefSelector
+	Logger   *slog.Logger
+}
+
+func NewGitTagTagDeducer(path string, selector gitutil.RefSelector, logger *slog.Logger) *GitTagTagDeducer {
+	return &GitTagTagDeducer{
+		Path:     path,
+		Selector: selector,
+		Logger:   logger,
+	}
+}
+
+func (d *GitTagTagDeducer) Deduce(ctx context.Context) (string, error) {
+	tags, err := gitutil.HeadTags(d.Path)
+	if err != nil {
+		return "", fmt.Errorf("getting local git tags: %w", err)
+	}
+
+	tag, err := d.Selector.Select(tags)
+	if err != nil {
+		return "", fmt.Errorf("selecting git tag: %w", err)
+	}
+
+	imageTag := invalidImageTagChars.ReplaceAllString(tag.Name().Short(), "-")
+	d.Logger.InfoContext(ctx, "deduced tag from git tag", "git_tag", tag.Name().Short(), "tag", imageTag)
+	return imageTag, nil
+}
+
+// PrefixedTagDeducer prefixes the tag deduced by another deducer, such as to scope the
+// images of a pull request apart from those of branches.
+type PrefixedTagDeducer struct {
+	Prefix  string
+	Deducer TagDeducer
+}
+
+func NewPrefixedTagDeducer(prefix string, deducer TagDeducer) *PrefixedTagDeducer {
+	return &PrefixedTagDeducer{
+		Prefix:  prefix,
+		Deducer: deducer,
+	}
+}
+
+func (d *PrefixedTagDeducer) Deduce(ctx context.Context) (string, error) {
+	tag, err := d.Deducer.Deduce(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return d.Prefix + "-" + tag, nil
+}
@@ -0,0 +1,28 @@
+package trigger
+
+// ChannelTriggerer is triggered directly, such as by a supervisor which has itself seen
+// that the remote changed.
+type ChannelTriggerer struct {
+	triggered chan struct{}
+}
+
+func NewChannelTriggerer() *ChannelTriggerer {
+	return &ChannelTriggerer{
+		// As for webhooks, buffer a single notification so that it is not lost if a build
+		// is in progress
+		triggered: make(chan struct{}, 1),
+	}
+}
+
+func (t *ChannelTriggerer) Triggered() <-chan struct{} {
+	return t.triggered
+}
+
+// Trigger notifies that the remote may have changed.
+func (t *ChannelTriggerer) Trigger() {
+	select {
+	case t.triggered <- struct{}{}:
+	default:
+		// A trigger is already pending
+	}
+}
@@ -0,0 +1,173 @@
+package trigger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+)
+
+// Triggerer is a source of notifications that the remote may have changed, allowing
+// changes to be acted upon without waiting for the next poll.
+type Triggerer interface {
+	Triggered() <-chan struct{}
+}
+
+// maxPayloadSize is the largest push event payload that will be read.
+// GitHub caps payloads at 25MB, which is the largest of the supported providers.
+const maxPayloadSize = 25 << 20
+
+var (
+	errUnsupportedEvent = errors.New("unsupported event")
+	errBadSignature     = errors.New("signature verification failed")
+)
+
+// WebhookTriggerer receives push event webhooks from GitHub, GitLab or Gitea and
+// triggers when a push to a watched ref is received.
+type WebhookTriggerer struct {
+	Refs   gitutil.RefMatcher
+	Secret []byte
+	Logger *slog.Logger
+
+	triggered chan struct{}
+}
+
+func NewWebhookTriggerer(refs gitutil.RefMatcher, secret string, logger *slog.Logger) *WebhookTriggerer {
+	return &WebhookTriggerer{
+		Refs:   refs,
+		Secret: []byte(secret),
+		Logger: logger,
+		// Buffer a single notification so that a push arriving while a build is in
+		// progress is not lost, but a burst of pushes causes only one extra check.
+		triggered: make(chan struct{}, 1),
+	}
+}
+
+func (t *WebhookTriggerer) Triggered() <-chan struct{} {
+	return t.triggered
+}
+
+func (t *WebhookTriggerer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+	if err != nil {
+		t.Logger.Warn("error reading webhook payload", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "error reading payload", http.StatusBadRequest)
+		return
+	}
+
+	ref, err := t.verify(r.Header, payload)
+	if errors.Is(err, errUnsupportedEvent) {
+		// Not an error: providers send pings and other events we do not care about
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if errors.Is(err, errBadSignature) {
+		t.Logger.Warn("rejecting webhook", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	} else if err != nil {
+		t.Logger.Warn("error processing webhook", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if !t.Refs.Matches(gitplumbing.ReferenceName(ref)) {
+		t.Logger.Info("ignoring push event", "ref", ref)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	t.Logger.Info("received push event", "ref", ref)
+	select {
+	case t.triggered <- struct{}{}:
+	default:
+		// A trigger is already pending, which will pick up this change too
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verify authenticates the push event according to the conventions of the provider
+// that sent it, and returns the ref that was pushed to.
+func (t *WebhookTriggerer) verify(header http.Header, payload []byte) (string, error) {
+	// Gitea also sends GitHub-compatible headers, so it must be detected first
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		if header.Get("X-Gitea-Event") != "push" {
+			return "", errUnsupportedEvent
+		}
+
+		if err := t.verifyHMAC(header.Get("X-Gitea-Signature"), payload); err != nil {
+			return "", err
+		}
+	case header.Get("X-GitHub-Event") != "":
+		if header.Get("X-GitHub-Event") != "push" {
+			return "", errUnsupportedEvent
+		}
+
+		signature := header.Get("X-Hub-Signature-256")
+		if !strings.HasPrefix(signature, "sha256=") {
+			return "", fmt.Errorf("%w: missing sha256 signature", errBadSignature)
+		}
+
+		if err := t.verifyHMAC(strings.TrimPrefix(signature, "sha256="), payload); err != nil {
+			return "", err
+		}
+	case header.Get("X-Gitlab-Event") != "":
+		// GitLab distinguishes pushes of tags from those of branches
+		if event := header.Get("X-Gitlab-Event"); event != "Push Hook" && event != "Tag Push Hook" {
+			return "", errUnsupportedEvent
+		}
+
+		// GitLab does not sign payloads, instead sending the shared secret verbatim
+		token := []byte(header.Get("X-Gitlab-Token"))
+		if subtle.ConstantTimeCompare(token, t.Secret) != 1 {
+			return "", fmt.Errorf("%w: token mismatch", errBadSignature)
+		}
+	default:
+		return "", errUnsupportedEvent
+	}
+
+	event := new(pushEvent)
+	if err := json.Unmarshal(payload, event); err != nil {
+		return "", fmt.Errorf("decoding push event payload: %w", err)
+	}
+
+	return event.Ref, nil
+}
+
+func (t *WebhookTriggerer) verifyHMAC(signature string, payload []byte) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature: %v", errBadSignature, err)
+	}
+
+	mac := hmac.New(sha256.New, t.Secret)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("%w: HMAC mismatch", errBadSignature)
+	}
+
+	return nil
+}
+
+// pushEvent is the subset of the push event payload common to all supported providers.
+type pushEvent struct {
+	Ref string `json:"ref"`
+}
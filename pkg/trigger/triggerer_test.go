@@ -0,0 +1,193 @@
+package trigger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+const (
+	testSecret  = "s3cr3t"
+	testBranch  = "master"
+	testPayload = `{"ref":"refs/heads/master"}`
+)
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookTriggerer(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        map[string]string
+		payload       string
+		wantStatus    int
+		wantTriggered bool
+	}{
+		{
+			name: "GitHub push with valid signature",
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": "sha256=" + sign(testSecret, testPayload),
+			},
+			payload:       testPayload,
+			wantStatus:    http.StatusAccepted,
+			wantTriggered: true,
+		},
+		{
+			name: "GitHub push with invalid signature",
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": "sha256=" + sign("wrong", testPayload),
+			},
+			payload:    testPayload,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "GitHub push without signature",
+			header: map[string]string{
+				"X-GitHub-Event": "push",
+			},
+			payload:    testPayload,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "GitHub ping",
+			header: map[string]string{
+				"X-GitHub-Event":      "ping",
+				"X-Hub-Signature-256": "sha256=" + sign(testSecret, "{}"),
+			},
+			payload:    "{}",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "GitHub push to other branch",
+			header: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": "sha256=" + sign(testSecret, `{"ref":"refs/heads/other"}`),
+			},
+			payload:    `{"ref":"refs/heads/other"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "Gitea push with valid signature",
+			header: map[string]string{
+				"X-Gitea-Event":     "push",
+				"X-GitHub-Event":    "push",
+				"X-Gitea-Signature": sign(testSecret, testPayload),
+			},
+			payload:       testPayload,
+			wantStatus:    http.StatusAccepted,
+			wantTriggered: true,
+		},
+		{
+			name: "Gitea push with invalid signature",
+			header: map[string]string{
+				"X-Gitea-Event":     "push",
+				"X-Gitea-Signature": sign("wrong", testPayload),
+			},
+			payload:    testPayload,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "GitLab push with valid token",
+			header: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": testSecret,
+			},
+			payload:       testPayload,
+			wantStatus:    http.StatusAccepted,
+			wantTriggered: true,
+		},
+		{
+			name: "GitLab push with invalid token",
+			header: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": "wrong",
+			},
+			payload:    testPayload,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Unknown provider",
+			header:     map[string]string{},
+			payload:    testPayload,
+			wantStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			triggerer := NewWebhookTriggerer(gitutil.NewBranchSelector(testBranch), testSecret, testLogger)
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(test.payload))
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			triggerer.ServeHTTP(rec, req)
+
+			if rec.Code != test.wantStatus {
+				t.Errorf("expected status %d, got %d", test.wantStatus, rec.Code)
+			}
+
+			select {
+			case <-triggerer.Triggered():
+				if !test.wantTriggered {
+					t.Error("expected no trigger, but was triggered")
+				}
+			default:
+				if test.wantTriggered {
+					t.Error("expected trigger, but was not triggered")
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookTriggererMatchesTags(t *testing.T) {
+	selector, err := gitutil.NewTagSelector("v*", ">=1.0.0")
+	if err != nil {
+		t.Fatalf("creating selector: %v", err)
+	}
+
+	tests := map[string]bool{
+		`{"ref":"refs/tags/v1.4.2"}`:  true,
+		`{"ref":"refs/tags/v0.9.0"}`:  false,
+		`{"ref":"refs/tags/release"}`: false,
+		`{"ref":"refs/heads/master"}`: false,
+	}
+
+	for payload, wantTriggered := range tests {
+		triggerer := NewWebhookTriggerer(selector, testSecret, testLogger)
+
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+		req.Header.Set("X-Gitlab-Event", "Tag Push Hook")
+		req.Header.Set("X-Gitlab-Token", testSecret)
+		triggerer.ServeHTTP(httptest.NewRecorder(), req)
+
+		select {
+		case <-triggerer.Triggered():
+			if !wantTriggered {
+				t.Errorf("expected %s not to trigger, but did", payload)
+			}
+		default:
+			if wantTriggered {
+				t.Errorf("expected %s to trigger, but did not", payload)
+			}
+		}
+	}
+}
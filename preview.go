@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/health"
+	"github.com/jhwbarlow/mockcicd/pkg/install"
+	"github.com/jhwbarlow/mockcicd/pkg/metrics"
+	"github.com/jhwbarlow/mockcicd/pkg/preview"
+	"github.com/jhwbarlow/mockcicd/pkg/trigger"
+)
+
+// previewSupervisor deploys a preview environment for each branch or pull request listed,
+// running a pipeline for it until its ref is removed, whereupon the environment is torn down.
+type previewSupervisor struct {
+	lister preview.Lister
+	// newEnvironment returns the pipeline deploying the ref, and the uninstaller which
+	// tears down what it installed
+	newEnvironment func(ref gitplumbing.ReferenceName) (*pipeline, install.Uninstaller)
+	// deployed returns the uninstaller of each environment already deployed, by name, so
+	// that those whose refs were removed while not running are torn down. May be nil, in
+	// which case they are not.
+	deployed  func(ctx context.Context) (map[string]install.Uninstaller, error)
+	triggerer trigger.Triggerer           // May be nil, in which case only polling is used
+	health    *health.Monitor             // May be nil, in which case health is not reported
+	metrics   *metrics.PrometheusRecorder // May be nil, in which case environments record no metrics
+	logger    *slog.Logger
+
+	pollPeriod      time.Duration
+	listTimeout     time.Duration
+	teardownTimeout time.Duration
+
+	refs         gitutil.Refs
+	environments map[gitplumbing.ReferenceName]*previewEnvironment
+	swept        bool
+	wg           sync.WaitGroup
+}
+
+// previewEnvironment is the pipeline deploying a ref, running in its own goroutine.
+type previewEnvironment struct {
+	pipeline    *pipeline
+	uninstaller install.Uninstaller
+	triggerer   *trigger.ChannelTriggerer
+
+	done     chan struct{}
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// failed is set if setup failed, in which case the pipeline is not running
+	failed atomic.Bool
+}
+
+func (s *previewSupervisor) run(ctx context.Context, done <-chan struct{}) {
+	// Receiving from a nil channel blocks forever, so if there is no triggerer,
+	// the trigger case will never be selected.
+	var triggered <-chan struct{}
+	if s.triggerer != nil {
+		triggered = s.triggerer.Triggered()
+	}
+
+	defer s.stop()
+
+	for {
+		s.sync(ctx)
+		if s.health != nil {
+			s.health.Beat()
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(s.pollPeriod):
+		case <-triggered:
+			s.logger.InfoContext(ctx, "change notification received, listing refs")
+		}
+	}
+}
+
+// stop stops the pipeline of every environment, allowing their in-flight work to complete.
+// The environments are not torn down.
+func (s *previewSupervisor) stop() {
+	for _, env := range s.environments {
+		env.stopOnce.Do(func() { close(env.done) })
+	}
+	s.wg.Wait()
+}
+
+// sync starts an environment for each ref added, triggers that of each ref updated and
+// tears down that of each ref removed.
+func (s *previewSupervisor) sync(ctx context.Context) {
+	listCtx, cancel := withTimeout(ctx, s.listTimeout)
+	refs, err := s.lister.List(listCtx)
+	cancel()
+	if err != nil {
+		// If there is an error, try again next time
+		s.logger.WarnContext(ctx, "error listing refs", "error", err)
+		return
+	}
+
+	if s.environments == nil {
+		s.environments = make(map[gitplumbing.ReferenceName]*previewEnvironment)
+	}
+
+	diff := gitutil.DiffRefs(s.refs, refs)
+	s.refs = refs
+
+	if s.deployed != nil && !s.swept {
+		s.sweep(ctx, refs)
+	}
+
+	// Environments whose teardown failed are retried, so all those of unlisted refs are
+	// torn down rather than only those of the refs just removed
+	for name, env := range s.environments {
+		if _, ok := refs[name]; !ok {
+			s.teardown(ctx, name, env)
+		}
+	}
+
+	for _, name := range diff.Added {
+		s.start(ctx, name)
+	}
+
+	for _, name := range diff.Updated {
+		// An environment whose setup failed is started afresh, as the change may fix it
+		if env, ok := s.environments[name]; ok && !env.failed.Load() {
+			env.triggerer.Trigger()
+		} else {
+			s.start(ctx, name)
+		}
+	}
+
+	if s.health != nil {
+		s.health.Ready()
+	}
+}
+
+// sweep tears down each environment deployed before starting whose ref is not listed, as it
+// was removed while not running. Should any teardown fail, all are swept again next time.
+func (s *previewSupervisor) sweep(ctx context.Context, refs gitutil.Refs) {
+	listCtx, cancel := withTimeout(ctx, s.listTimeout)
+	deployed, err := s.deployed(listCtx)
+	cancel()
+	if err != nil {
+		// If there is an error, try again next time
+		s.logger.WarnContext(ctx, "error listing deployed preview environments", "error", err)
+		return
+	}
+
+	listed := make(map[string]bool, len(refs))
+	for name := range refs {
+		listed[preview.Environment(name)] = true
+	}
+
+	swept := true
+	for environment, uninstaller := range deployed {
+		if listed[environment] {
+			continue
+		}
+
+		s.logger.InfoContext(ctx, "ref of deployed preview environment not listed, tearing down", "environment", environment)
+		teardownCtx, cancel := withTimeout(ctx, s.teardownTimeout)
+		err := uninstaller.Uninstall(teardownCtx)
+		cancel()
+		if err != nil {
+			s.logger.WarnContext(ctx, "error uninstalling preview environment", "environment", environment, "error", err)
+			swept = false
+			continue
+		}
+		s.logger.InfoContext(ctx, "preview environment torn down", "environment", environment)
+	}
+
+	s.swept = swept
+}
+
+func (s *previewSupervisor) start(ctx context.Context, name gitplumbing.ReferenceName) {
+	p, uninstaller := s.newEnvironment(name)
+
+	// Distinct branches may be named alike once made valid names for the environment
+	for other, env := range s.environments {
+		if other != name && env.pipeline.srcDirPath == p.srcDirPath {
+			s.logger.WarnContext(ctx, "ref has the same preview environment as another, skipping",
+				"ref", name.String(),
+				"other_ref", other.String())
+			return
+		}
+	}
+
+	s.logger.InfoContext(ctx, "starting preview environment", "ref", name.String())
+	env := &previewEnvironment{
+		pipeline:    p,
+		uninstaller: uninstaller,
+		triggerer:   trigger.NewChannelTriggerer(),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	// The refs are listed every poll period and the pipeline of each updated triggered,
+	// so the pipelines do not poll the remote too
+	p.triggerer = env.triggerer
+	p.pollPeriod = 0
+	// Each environment is monitored as a component of the whole, and labels its own metrics
+	if s.health != nil {
+		p.health = s.health.Component(name.String())
+	}
+	if s.metrics != nil {
+		p.metrics = s.metrics.ForEnvironment(preview.Environment(name))
+	}
+	s.environments[name] = env
+
+	envCtx, cancel := context.WithCancel(ctx)
+	env.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(env.stopped)
+		defer cancel()
+
+		if err := p.setup(envCtx); err != nil {
+			// The environment is started afresh upon the next change to the ref
+			p.logger.WarnContext(envCtx, "error setting up preview environment", "error", err)
+			env.failed.Store(true)
+			return
+		}
+
+		// Run does not return until the environment is stopped
+		p.run(envCtx, env.done)
+	}()
+}
+
+// teardown stops the environment's pipeline, cancelling any in-flight work as the ref no
+// longer exists, and uninstalls it. The environment is forgotten only once torn down.
+func (s *previewSupervisor) teardown(ctx context.Context, name gitplumbing.ReferenceName, env *previewEnvironment) {
+	ref := name.String()
+	s.logger.InfoContext(ctx, "ref removed, tearing down preview environment", "ref", ref)
+
+	env.stopOnce.Do(func() { close(env.done) })
+	env.cancel()
+	<-env.stopped
+
+	// The stopped pipeline no longer reports its progress, whether or not it is uninstalled
+	if s.health != nil {
+		s.health.RemoveComponent(ref)
+	}
+
+	teardownCtx, cancel := withTimeout(ctx, s.teardownTimeout)
+	defer cancel()
+	if err := env.uninstaller.Uninstall(teardownCtx); err != nil {
+		// If there is an error, try again next time
+		s.logger.WarnContext(ctx, "error uninstalling preview environment", "ref", ref, "error", err)
+		return
+	}
+
+	if err := os.RemoveAll(env.pipeline.srcDirPath); err != nil {
+		s.logger.WarnContext(ctx, "error removing source code of preview environment", "ref", ref, "error", err)
+	}
+
+	if s.metrics != nil {
+		s.metrics.RemoveEnvironment(preview.Environment(name))
+	}
+
+	delete(s.environments, name)
+	s.logger.InfoContext(ctx, "preview environment torn down", "ref", ref)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/prometheus/client_golang/prometheus"
+
+	gitutil "github.com/jhwbarlow/mockcicd/pkg/git"
+	"github.com/jhwbarlow/mockcicd/pkg/health"
+	"github.com/jhwbarlow/mockcicd/pkg/install"
+	"github.com/jhwbarlow/mockcicd/pkg/metrics"
+)
+
+// mockPreviewEnvironments creates pipelines deploying refs with mocks, which signal
+// upon the initial install and upon each check.
+type mockPreviewEnvironments struct {
+	dir string
+
+	installed    map[string]chan struct{}
+	checked      map[string]chan struct{}
+	uninstallers map[string]*mockUninstaller
+}
+
+func newMockPreviewEnvironments(t *testing.T) *mockPreviewEnvironments {
+	return &mockPreviewEnvironments{
+		dir:          t.TempDir(),
+		installed:    make(map[string]chan struct{}),
+		checked:      make(map[string]chan struct{}),
+		uninstallers: make(map[string]*mockUninstaller),
+	}
+}
+
+func (me *mockPreviewEnvironments) newEnvironment(ref gitplumbing.ReferenceName) (*pipeline, install.Uninstaller) {
+	branch := ref.Short()
+	acked := make(chan struct{})
+	close(acked)
+
+	me.installed[branch] = make(chan struct{})
+	me.checked[branch] = make(chan struct{})
+	me.uninstallers[branch] = newMockUninstaller()
+
+	srcDirPath := filepath.Join(me.dir, filepath.Base(branch))
+	os.MkdirAll(srcDirPath, 0o755)
+
+	p := &pipeline{
+		logger:     discardLogger,
+		obtainer:   newMockObtainer(nil),
+		tagDeducer: newMockTagDeducer("mocktag"),
+		builder:    newMockBuilder(),
+		pusher:     newMockPusher(),
+		installer:  newMockAsyncInstaller(me.installed[branch], acked),
+		inspector:  newMockInspector("", ""),
+		checker:    newMockAsyncChecker(false, me.checked[branch], acked),
+		resolver:   newMockResolver(),
+		store:      newMockStore(),
+		srcDirPath: srcDirPath,
+		pollPeriod: time.Hour,
+	}
+
+	return p, me.uninstallers[branch]
+}
+
+func await(t *testing.T, signal <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-signal:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestPreviewSupervisorTearsDownRemovedBranches(t *testing.T) {
+	hash := gitplumbing.NewHash("1111111111111111111111111111111111111111")
+	environments := newMockPreviewEnvironments(t)
+	s := &previewSupervisor{
+		lister: newMockLister(
+			gitutil.Refs{"refs/heads/feature/a": hash, "refs/heads/feature/b": hash},
+			gitutil.Refs{"refs/heads/feature/a": hash}),
+		newEnvironment: environments.newEnvironment,
+		logger:         discardLogger,
+	}
+	defer s.stop()
+
+	s.sync(context.Background())
+	await(t, environments.installed["feature/a"], "feature/a to be installed")
+	await(t, environments.installed["feature/b"], "feature/b to be installed")
+
+	s.sync(context.Background())
+
+	if !environments.uninstallers["feature/b"].called() {
+		t.Error("expected Uninstaller.Uninstall() to be called for removed branch, but was not")
+	}
+	if environments.uninstallers["feature/a"].called() {
+		t.Error("expected Uninstaller.Uninstall() not to be called for remaining branch, but was")
+	}
+
+	if _, err := os.Stat(filepath.Join(environments.dir, "b")); !os.IsNotExist(err) {
+		t.Errorf("expected source code of removed branch to be removed, got %v", err)
+	}
+
+	if _, ok := s.environments["refs/heads/feature/b"]; ok {
+		t.Error("expected environment of removed branch to be forgotten")
+	}
+}
+
+func TestPreviewSupervisorTearsDownEnvironmentsOfRefsRemovedWhileNotRunning(t *testing.T) {
+	hash := gitplumbing.NewHash("1111111111111111111111111111111111111111")
+	environments := newMockPreviewEnvironments(t)
+	listed := newMockUninstaller()
+	removed := newMockUninstaller()
+	deployedCalls := 0
+	s := &previewSupervisor{
+		lister:         newMockLister(gitutil.Refs{"refs/heads/feature/a": hash}),
+		newEnvironment: environments.newEnvironment,
+		deployed: func(ctx context.Context) (map[string]install.Uninstaller, error) {
+			deployedCalls++
+			return map[string]install.Uninstaller{"feature/a": listed, "feature/b": removed}, nil
+		},
+		logger: discardLogger,
+	}
+	defer s.stop()
+
+	s.sync(context.Background())
+	await(t, environments.installed["feature/a"], "feature/a to be installed")
+
+	if !removed.called() {
+		t.Error("expected Uninstaller.Uninstall() to be called for environment of removed branch, but was not")
+	}
+	if listed.called() {
+		t.Error("expected Uninstaller.Uninstall() not to be called for environment of listed branch, but was")
+	}
+
+	s.sync(context.Background())
+
+	if deployedCalls != 1 {
+		t.Errorf("expected deployed environments to be listed once, got %d", deployedCalls)
+	}
+}
+
+// hasEnvironmentMetrics determines if any metric gathered from the registry is labelled
+// with the environment.
+func hasEnvironmentMetrics(t *testing.T, registry *prometheus.Registry, environment string) bool {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "environment" && label.GetValue() == environment {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func TestPreviewSupervisorMonitorsEachEnvironment(t *testing.T) {
+	hash := gitplumbing.NewHash("1111111111111111111111111111111111111111")
+	environments := newMockPreviewEnvironments(t)
+	registry := prometheus.NewRegistry()
+	s := &previewSupervisor{
+		lister: newMockLister(
+			gitutil.Refs{"refs/heads/feature/a": hash, "refs/heads/feature/b": hash},
+			gitutil.Refs{"refs/heads/feature/a": hash}),
+		newEnvironment: environments.newEnvironment,
+		health:         health.NewMonitor(time.Minute),
+		metrics:        metrics.NewPrometheusRecorder(registry),
+		logger:         discardLogger,
+	}
+	defer s.stop()
+
+	s.sync(context.Background())
+	await(t, environments.installed["feature/a"], "feature/a to be installed")
+	await(t, environments.installed["feature/b"], "feature/b to be installed")
+
+	for name, env := range s.environments {
+		if env.pipeline.health == nil {
+			t.Errorf("expected health of %s to be reported, but was not", name)
+		}
+		if env.pipeline.metrics == nil {
+			t.Errorf("expected metrics of %s to be recorded, but were not", name)
+		}
+	}
+
+	if !hasEnvironmentMetrics(t, registry, "feature/b") {
+		t.Error("expected metrics labelled with environment of branch")
+	}
+
+	s.sync(context.Background())
+
+	if hasEnvironmentMetrics(t, registry, "feature/b") {
+		t.Error("expected metrics of removed branch to be removed")
+	}
+	if !hasEnvironmentMetrics(t, registry, "feature/a") {
+		t.Error("expected metrics of remaining branch to be kept")
+	}
+}
+
+func TestPreviewSupervisorTriggersUpdatedBranches(t *testing.T) {
+	environments := newMockPreviewEnvironments(t)
+	s := &previewSupervisor{
+		lister: newMockLister(
+			gitutil.Refs{"refs/heads/feature/a": gitplumbing.NewHash("1111111111111111111111111111111111111111")},
+			gitutil.Refs{"refs/heads/feature/a": gitplumbing.NewHash("2222222222222222222222222222222222222222")}),
+		newEnvironment: environments.newEnvironment,
+		logger:         discardLogger,
+	}
+	defer s.stop()
+
+	s.sync(context.Background())
+	await(t, environments.installed["feature/a"], "feature/a to be installed")
+
+	if pollPeriod := s.environments["refs/heads/feature/a"].pipeline.pollPeriod; pollPeriod != 0 {
+		t.Errorf("expected pipeline to only check upon trigger, got poll period %v", pollPeriod)
+	}
+
+	// The pipeline only checks if triggered
+	s.sync(context.Background())
+	await(t, environments.checked["feature/a"], "feature/a to be checked")
+}
@@ -9,4 +9,5 @@ MOCKCICD_HELMK8SNAMESPACE="algolia" \
 MOCKCICD_HELMRELEASENAME="algolia-instant-search-demo" \
 MOCKCICD_INSTALLTIMEOUT="5m" \
 MOCKCICD_POLLPERIOD="1m" \
-go run main.go	
\ No newline at end of file
+MOCKCICD_HISTORYFILEPATH="tmp/history.jsonl" \
+go run .
\ No newline at end of file